@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	util "template-mobile-app-api/util"
+)
+
+// runGeocodeCommand は一括ジオコーディングのサブコマンド
+//
+//	go run . geocode -in locations.csv -column 実施場所 -out result.json
+//
+// POST /api/v1/geocode/batch と同じ処理で、CSV (1行目は見出し) または JSON ({"rows": [...]}) を読み込む
+func runGeocodeCommand(args []string) int {
+	flags := flag.NewFlagSet("geocode", flag.ExitOnError)
+	in := flags.String("in", "", "入力ファイル (.csv または .json, 省略時は標準入力のCSV)")
+	column := flags.String("column", "query", "CSV の地名の列名")
+	out := flags.String("out", "", "出力ファイル (省略時は標準出力)")
+	flags.Parse(args)
+
+	var input io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ファイルオープンエラー:", err)
+			return 1
+		}
+		defer f.Close()
+		input = f
+	}
+
+	var rows []util.GeocodeBatchRow
+	if strings.EqualFold(filepath.Ext(*in), ".json") {
+		var request util.GeocodeBatchRequest
+		if err := json.NewDecoder(input).Decode(&request); err != nil {
+			fmt.Fprintln(os.Stderr, "JSONデコードエラー:", err)
+			return 1
+		}
+		rows = request.Rows
+	} else {
+		parsed, err := util.ParseGeocodeCSV(input, *column)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "CSV読み込みエラー:", err)
+			return 1
+		}
+		rows = parsed
+	}
+
+	response := util.GeocodeBatch(context.Background(), rows)
+	fmt.Fprintf(os.Stderr, "%d件中 %d件の座標が見つかりました (未解決 %d件)\n", len(rows), len(response.Results), len(response.Unresolved))
+
+	var output io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ファイル作成エラー:", err)
+			return 1
+		}
+		defer f.Close()
+		output = f
+	}
+	enc := json.NewEncoder(output)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(response); err != nil {
+		fmt.Fprintln(os.Stderr, "JSONエンコードエラー:", err)
+		return 1
+	}
+	return 0
+}
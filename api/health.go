@@ -1,23 +1,39 @@
 package main
+
 import (
 	"net/http"
 	"time"
+
 	"github.com/gin-gonic/gin"
+
+	"template-mobile-app-api/httpclient"
 )
 
 // getHealth godoc
 // @Summary Health check endpoint
-// @Description Returns the health status of the API
+// @Description Returns the health status of the API and the circuit breaker state of each upstream host
 // @Tags health
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
 // @Router /health [get]
 func getHealth(c *gin.Context) {
+	breakers := httpclient.Default().Breakers()
+
+	// 外部APIのブレーカーが開いていてもAPI自体は応答できるので degraded とする
+	status := "healthy"
+	for _, b := range breakers {
+		if b.State != httpclient.BreakerClosed {
+			status = "degraded"
+			break
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"status":    "healthy",
-		"timestamp": time.Now().Format(time.RFC3339),
-		"service":   "template-mobile-app-api",
-		"version":   "1.0.0",
+		"status":           status,
+		"timestamp":        time.Now().Format(time.RFC3339),
+		"service":          "template-mobile-app-api",
+		"version":          "1.0.0",
+		"circuit_breakers": breakers,
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,119 @@
+package httpclient
+
+import (
+	"sort"
+	"time"
+)
+
+// BreakerState はサーキットブレーカーの状態
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // 通常通りリクエストを送る
+	BreakerOpen     BreakerState = "open"      // リクエストを送らずに失敗させる
+	BreakerHalfOpen BreakerState = "half_open" // 試しに1件だけ送る
+)
+
+// BreakerStatus はヘルスチェック用のブレーカーの状態
+type BreakerStatus struct {
+	Host                string       `json:"host"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+}
+
+// breaker はホストごとのサーキットブレーカー
+//
+// 連続失敗が threshold に達すると open になり、cooldown 経過後に half_open で
+// 1件だけ試行する。成功すれば closed に戻り、失敗すれば再び open になる。
+type breaker struct {
+	client *Client
+	host   string
+
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// breaker はホストに対応するブレーカーを返す
+func (c *Client) breaker(host string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[host]
+	if !ok {
+		b = &breaker{client: c, host: host, state: BreakerClosed}
+		c.breakers[host] = b
+	}
+	return b
+}
+
+func (b *breaker) allow() bool {
+	b.client.mu.Lock()
+	defer b.client.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.client.config.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// 試行中のリクエストの結果が出るまでは通さない
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	b.client.mu.Lock()
+	defer b.client.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *breaker) failure() {
+	b.client.mu.Lock()
+	defer b.client.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.client.config.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release は結果を数えずに試行を終える (呼び出し元がキャンセルした場合)
+func (b *breaker) release() {
+	b.client.mu.Lock()
+	defer b.client.mu.Unlock()
+	b.probing = false
+}
+
+// Breakers はホストごとのブレーカーの状態をホスト名順に返す
+func (c *Client) Breakers() []BreakerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statuses := make([]BreakerStatus, 0, len(c.breakers))
+	for _, b := range c.breakers {
+		status := BreakerStatus{
+			Host:                b.host,
+			State:               b.state,
+			ConsecutiveFailures: b.failures,
+		}
+		if b.state != BreakerClosed {
+			openedAt := b.openedAt
+			status.OpenedAt = &openedAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
@@ -0,0 +1,237 @@
+// Package httpclient は外部APIへのHTTPリクエストを共通化する
+//
+// リクエストごとのタイムアウト、冪等なリクエストのジッター付きリトライ、
+// ホストごとのサーキットブレーカーを提供する。
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen はサーキットブレーカーが開いているためリクエストを送らなかった場合のエラー
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Config はクライアントの設定
+type Config struct {
+	Timeout          time.Duration // 1回の試行あたりのタイムアウト
+	MaxRetries       int           // 冪等なリクエストの最大リトライ回数
+	BaseBackoff      time.Duration // リトライ間隔の基準値
+	MaxBackoff       time.Duration // リトライ間隔の上限
+	FailureThreshold int           // ブレーカーが開くまでの連続失敗回数
+	Cooldown         time.Duration // ブレーカーが開いてから試行を再開するまでの時間
+	UserAgent        string        // リクエストに User-Agent が無い場合に付ける値
+}
+
+// DefaultUserAgent は User-Agent の既定値 (ブラウザを装わず、アプリ名を名乗る)
+const DefaultUserAgent = "template-mobile-app-api/1.0"
+
+// Request は送信するリクエスト
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Idempotent が true の場合は失敗時にリトライする (GET/HEAD は常にリトライ対象)
+	Idempotent bool
+	// BeforeRetry はリトライの直前に呼ばれる (レート制限のトークン取得など)
+	BeforeRetry func(ctx context.Context) error
+	// HandleUnavailable が true の場合は 503 を Retry-After の有無によらずリトライせずに返す (呼び出し元が Retry-After に従う)
+	HandleUnavailable bool
+}
+
+// Response はボディを読み切ったレスポンス
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Client は共通のHTTPクライアント
+type Client struct {
+	config Config
+	http   *http.Client
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// New は Client を作成する
+func New(config Config) *Client {
+	return &Client{
+		config:   config,
+		http:     &http.Client{},
+		breakers: map[string]*breaker{},
+	}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default は環境変数から設定した共有クライアントを返す
+func Default() *Client {
+	defaultOnce.Do(func() {
+		defaultClient = New(Config{
+			Timeout:          getEnvDuration("HTTP_CLIENT_TIMEOUT", 10*time.Second),
+			MaxRetries:       getEnvInt("HTTP_CLIENT_MAX_RETRIES", 2),
+			BaseBackoff:      getEnvDuration("HTTP_CLIENT_BASE_BACKOFF", 200*time.Millisecond),
+			MaxBackoff:       getEnvDuration("HTTP_CLIENT_MAX_BACKOFF", 2*time.Second),
+			FailureThreshold: getEnvInt("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5),
+			Cooldown:         getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
+			UserAgent:        getEnvString("HTTP_USER_AGENT", DefaultUserAgent),
+		})
+	})
+	return defaultClient
+}
+
+// Get は GET リクエストを送る
+func (c *Client) Get(ctx context.Context, url string, header http.Header) (*Response, error) {
+	return c.Do(ctx, &Request{Method: http.MethodGet, URL: url, Header: header})
+}
+
+// Do はリクエストを送信し、ボディを読み切ったレスポンスを返す
+//
+// 200以外のステータスもエラーにはせず Response として返す。
+// 通信エラー・タイムアウト・5xx の場合、冪等なリクエストであればリトライする。
+// ただし Retry-After 付きの 503 はリトライせずにそのまま返す (429 もリトライしない)。
+func (c *Client) Do(ctx context.Context, r *Request) (*Response, error) {
+	probe, err := http.NewRequest(r.Method, r.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	b := c.breaker(probe.URL.Host)
+
+	retries := 0
+	if r.Idempotent || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		retries = c.config.MaxRetries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			if err := c.sleep(ctx, attempt); err != nil {
+				return nil, err
+			}
+			if r.BeforeRetry != nil {
+				if err := r.BeforeRetry(ctx); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if !b.allow() {
+			return nil, fmt.Errorf("%s: %w", b.host, ErrCircuitOpen)
+		}
+
+		resp, err := c.attempt(ctx, r)
+		if ctx.Err() != nil {
+			// 呼び出し元の切断・タイムアウトは外部APIの失敗に数えない
+			b.release()
+			return nil, ctx.Err()
+		}
+		if err == nil && resp.StatusCode < 500 {
+			b.success()
+			return resp, nil
+		}
+		b.failure()
+
+		if err != nil {
+			lastErr = err
+		} else if attempt == retries || !retryableStatus(resp.StatusCode) || (resp.StatusCode == http.StatusServiceUnavailable && (r.HandleUnavailable || resp.Header.Get("Retry-After") != "")) {
+			// Retry-After 付きの 503 は待ち時間を決められる呼び出し元 (osm など) に返す
+			return resp, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// attempt は1回分のリクエストを送る
+func (c *Client) attempt(ctx context.Context, r *Request) (*Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("User-Agent") == "" && c.config.UserAgent != "" {
+		req.Header.Set("User-Agent", c.config.UserAgent)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody}, nil
+}
+
+// sleep は指数バックオフ + フルジッターで待機する
+func (c *Client) sleep(ctx context.Context, attempt int) error {
+	backoff := c.config.BaseBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > c.config.MaxBackoff {
+		backoff = c.config.MaxBackoff
+	}
+	wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func getEnvString(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,127 @@
+// Package jpnorm は日本語の地名・住所の表記ゆれを吸収するための正規化を行う
+package jpnorm
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Fold は検索用のキーに変換する
+//
+//   - 全角英数・半角カナを NFKC で揃え、英字は小文字にする
+//   - ひらがなはカタカナにする
+//   - 「三丁目」「3-5-7」「3丁目5番地7号」などの丁目・番地を「3-5-7」の形に揃える
+//   - 「霞が関」「霞ヶ関」「霞ケ関」のような地名中のヶ・が・ケを揃える
+//   - 空白や記号を取り除く
+func Fold(s string) string {
+	s = norm.NFKC.String(s)
+	s = strings.ToLower(s)
+	s = FoldBlockNumbers(s)
+	s = ToKatakana(s)
+	s = foldSmallKe(s)
+
+	var b strings.Builder
+	for _, r := range s {
+		// 丁目・番地の区切りの - と交差点の × は残す
+		if r != '-' && r != '×' && (unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.Trim(collapseHyphens(b.String()), "-")
+}
+
+// ToKatakana はひらがなをカタカナに変換する
+func ToKatakana(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'ぁ' && r <= 'ゖ' {
+			return r + ('ァ' - 'ぁ')
+		}
+		return r
+	}, s)
+}
+
+// IsKana はカタカナ・ひらがな(と長音記号)だけで構成されているかを返す
+func IsKana(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.In(r, unicode.Hiragana, unicode.Katakana) && r != 'ー' {
+			return false
+		}
+	}
+	return true
+}
+
+// 各カナの母音 (拗音は小書きの文字で決まる)
+var kanaVowels = map[rune]byte{}
+
+func init() {
+	rows := map[byte]string{
+		'a': "アカサタナハマヤラワガザダバパァャヵ",
+		'i': "イキシチニヒミリギジヂビピィ",
+		'u': "ウクスツヌフムユルグズヅブプゥュヴ",
+		'e': "エケセテネヘメレゲゼデベペェヶ",
+		'o': "オコソトノホモヨロヲゴゾドボポォョ",
+	}
+	for vowel, kana := range rows {
+		for _, r := range kana {
+			kanaVowels[r] = vowel
+		}
+	}
+}
+
+// LooseKana は読みの長音の書き方の違いを無視するためのキーにする
+//
+// 「トウヨウ」「トーヨー」「トヨ」(ローマ字の toyo) が同じになるように、
+// 長音記号と、直前と同じ母音・オ段の後のウを取り除く。
+func LooseKana(s string) string {
+	s = ToKatakana(s)
+	var b strings.Builder
+	var prev byte
+	for _, r := range s {
+		switch r {
+		case 'ー':
+			continue
+		case 'ヲ':
+			r = 'オ'
+		case 'ヂ':
+			r = 'ジ'
+		case 'ヅ':
+			r = 'ズ'
+		}
+		vowel, isKana := kanaVowels[r]
+		if isKana && strings.ContainsRune("アイウエオ", r) && prev != 0 {
+			if vowel == prev || (r == 'ウ' && prev == 'o') {
+				continue
+			}
+		}
+		b.WriteRune(r)
+		prev = vowel
+	}
+	return b.String()
+}
+
+// foldSmallKe は漢字に挟まれた「ヶ」「ケ」「ガ」「ヵ」を「ケ」に揃える (霞が関 / 霞ヶ関)
+func foldSmallKe(s string) string {
+	runes := []rune(s)
+	for i := 1; i+1 < len(runes); i++ {
+		switch runes[i] {
+		case 'ヶ', 'ヵ', 'ガ', 'ケ':
+			if unicode.Is(unicode.Han, runes[i-1]) && unicode.Is(unicode.Han, runes[i+1]) {
+				runes[i] = 'ケ'
+			}
+		}
+	}
+	return string(runes)
+}
+
+func collapseHyphens(s string) string {
+	for strings.Contains(s, "--") {
+		s = strings.ReplaceAll(s, "--", "-")
+	}
+	return s
+}
@@ -0,0 +1,180 @@
+package jpnorm
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Location はオープンデータの「千代田区永田町一丁目付近」「外堀通りＸ第一京浜」のような地名を分解したもの
+type Location struct {
+	Raw       string   // 元の文字列
+	Ward      string   // 区市町村 (千代田区)
+	Area      string   // 区市町村より後の部分 (永田町1丁目)
+	Qualifier string   // 取り除いた「付近」「先」などの語
+	Roads     []string // 交差点表記 (Ｘ・× 区切り) の道路名
+}
+
+var (
+	// 東京都の区市町村 (「市ヶ谷」「町屋」のように先頭にある区・市・町は含めない)
+	wardPattern = regexp.MustCompile(`^(\p{Han}{1,3}?区|\p{Han}{1,4}?市|\p{Han}{1,3}郡\p{Han}{1,3}?[町村])`)
+	// 丁目までの部分 (番・号を除いた検索に使う)
+	chomePrefixPattern = regexp.MustCompile(`^.*?\d+丁目`)
+	// 数字の間のハイフンの異体字
+	hyphenPattern = regexp.MustCompile(`(\d)\s*[‐‑‒–—―−ー]\s*(\d)`)
+	spacePattern  = regexp.MustCompile(`\s+`)
+)
+
+// どこにあっても取り除く語 (末尾から順に調べる)
+var looseQualifiers = []string{"付近", "周辺", "辺り", "あたり", "近辺"}
+
+// 番地・交差点の後ろにある場合だけ取り除く語 (「駅前」「御成門前」などの地名は残す)
+var positionQualifiers = []string{"地先", "手前", "先", "前", "角"}
+
+// 位置を表す語を取り除いてよい直前の語
+var positionAnchors = []string{"丁目", "番地", "番", "号", "交差点"}
+
+// 交差点表記の区切り (NFKC で全角のＸは X になる)
+var roadSeparators = []rune{'X', 'x', '×', '✕'}
+
+// ParseLocation は地名を正規化して分解する
+//
+//   - 全角英数を NFKC で揃え、先頭の「東京都」を除く
+//   - 末尾の「付近」「周辺」と、番地・交差点の後の「先」「前」を除く
+//   - 住所の丁目・番・号の前の漢数字を算用数字にする (一ツ橋・六本木・麻布十番・一番町などの地名はそのまま)
+//   - 「外堀通りＸ第一京浜」のような交差点表記を道路名に分ける
+//   - 先頭の区市町村を Ward に分ける
+func ParseLocation(s string) Location {
+	l := Location{Raw: s}
+	s = norm.NFKC.String(s)
+	s = strings.TrimSpace(spacePattern.ReplaceAllString(s, " "))
+	s = strings.TrimPrefix(s, "東京都")
+
+	s, l.Qualifier = trimQualifiers(s)
+	s = ReplaceKanjiBlockNumbers(s)
+	for hyphenPattern.MatchString(s) {
+		s = hyphenPattern.ReplaceAllString(s, "$1-$2")
+	}
+
+	if m := wardPattern.FindString(s); m != "" && len(m) < len(s) {
+		l.Ward = m
+		s = strings.TrimSpace(s[len(m):])
+	}
+	l.Roads = splitRoads(s)
+	if len(l.Roads) >= 2 {
+		s = strings.Join(l.Roads, "×")
+	}
+	l.Area = s
+	return l
+}
+
+// Query はジオコーダに渡す文字列 (区市町村+残りの部分)
+func (l Location) Query() string {
+	return l.Ward + l.Area
+}
+
+// Queries はジオコーディングで順に試す文字列を返す
+// 番・号まで含めた住所 → 丁目まで → 町名だけ、の順に粗くする
+func (l Location) Queries() []string {
+	var queries []string
+	add := func(q string) {
+		if q == "" || q == l.Ward {
+			return
+		}
+		for _, existing := range queries {
+			if existing == q {
+				return
+			}
+		}
+		queries = append(queries, q)
+	}
+	add(l.Query())
+	if len(l.Roads) >= 2 {
+		return queries
+	}
+	if m := chomePrefixPattern.FindString(l.Area); m != "" {
+		add(l.Ward + m)
+		add(l.Ward + strings.TrimRight(strings.TrimSuffix(m, "丁目"), "0123456789"))
+	}
+	return queries
+}
+
+// trimQualifiers は末尾の位置を表す語を取り除き、取り除いた語を返す
+func trimQualifiers(s string) (string, string) {
+	qualifier := ""
+	for {
+		trimmed := false
+		for _, q := range looseQualifiers {
+			if rest, ok := strings.CutSuffix(s, q); ok && rest != "" {
+				s, qualifier, trimmed = rest, q+qualifier, true
+				break
+			}
+		}
+		for _, q := range positionQualifiers {
+			rest, ok := strings.CutSuffix(s, q)
+			if !ok || !hasPositionAnchor(rest) {
+				continue
+			}
+			s, qualifier, trimmed = rest, q+qualifier, true
+			break
+		}
+		if !trimmed {
+			return strings.TrimSpace(s), qualifier
+		}
+		s = strings.TrimSpace(s)
+	}
+}
+
+func hasPositionAnchor(s string) bool {
+	for _, anchor := range positionAnchors {
+		if strings.HasSuffix(s, anchor) {
+			return true
+		}
+	}
+	// 「永田町1-2」のような番地の数字
+	r := []rune(s)
+	return len(r) > 0 && unicode.IsDigit(r[len(r)-1])
+}
+
+// splitRoads は「外堀通りＸ第一京浜」のような交差点表記を道路名に分ける
+// 英字の地名を誤って分けないよう、区切りの両側が英数字以外の場合だけ分ける
+func splitRoads(s string) []string {
+	runes := []rune(s)
+	var roads []string
+	start := 0
+	for i, r := range runes {
+		if !isRoadSeparator(r) || i == 0 || i == len(runes)-1 {
+			continue
+		}
+		if isASCIIAlnum(runes[i-1]) || isASCIIAlnum(runes[i+1]) {
+			continue
+		}
+		roads = append(roads, strings.TrimSpace(string(runes[start:i])))
+		start = i + 1
+	}
+	if roads == nil {
+		return nil
+	}
+	roads = append(roads, strings.TrimSpace(string(runes[start:])))
+	for _, road := range roads {
+		if road == "" {
+			return nil
+		}
+	}
+	return roads
+}
+
+func isRoadSeparator(r rune) bool {
+	for _, sep := range roadSeparators {
+		if r == sep {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCIIAlnum(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
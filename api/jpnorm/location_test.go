@@ -0,0 +1,50 @@
+package jpnorm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		in        string
+		ward      string
+		area      string
+		qualifier string
+	}{
+		// warningIntersection.json の実施場所
+		{"千代田区永田町1丁目付近", "千代田区", "永田町1丁目", "付近"},
+		{"目黒区目黒本町2丁目周辺", "目黒区", "目黒本町2丁目", "周辺"},
+		{"西多摩郡瑞穂町長岡1丁目付近", "西多摩郡瑞穂町", "長岡1丁目", "付近"},
+		{"葛飾区四つ木2丁目付近", "葛飾区", "四つ木2丁目", "付近"},
+		{"東京都千代田区永田町一丁目付近", "千代田区", "永田町1丁目", "付近"},
+		{"千代田区永田町１－２先", "千代田区", "永田町1-2", "先"},
+		// バス停・駅の名前 (番号の付いた地名と「駅前」は残す)
+		{"麻布十番駅前", "", "麻布十番駅前", ""},
+		{"千代田区一番町", "千代田区", "一番町", ""},
+		{"九段三丁目", "", "九段3丁目", ""},
+		{"六本木一丁目駅前", "", "六本木1丁目駅前", ""},
+	}
+	for _, tt := range tests {
+		l := ParseLocation(tt.in)
+		if l.Ward != tt.ward || l.Area != tt.area || l.Qualifier != tt.qualifier {
+			t.Errorf("ParseLocation(%q) = {Ward: %q, Area: %q, Qualifier: %q}; want {%q, %q, %q}",
+				tt.in, l.Ward, l.Area, l.Qualifier, tt.ward, tt.area, tt.qualifier)
+		}
+	}
+}
+
+func TestParseLocationRoads(t *testing.T) {
+	l := ParseLocation("外堀通りＸ第一京浜")
+	if want := []string{"外堀通り", "第一京浜"}; !slices.Equal(l.Roads, want) {
+		t.Errorf("Roads = %q; want %q", l.Roads, want)
+	}
+}
+
+func TestLocationQueries(t *testing.T) {
+	got := ParseLocation("千代田区永田町一丁目5番地付近").Queries()
+	want := []string{"千代田区永田町1丁目5番地", "千代田区永田町1丁目", "千代田区永田町"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Queries() = %q; want %q", got, want)
+	}
+}
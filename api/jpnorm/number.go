@@ -0,0 +1,165 @@
+package jpnorm
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var kanjiDigits = map[rune]int{
+	'〇': 0, '零': 0, '一': 1, '二': 2, '三': 3, '四': 4,
+	'五': 5, '六': 6, '七': 7, '八': 8, '九': 9,
+}
+
+var kanjiUnits = map[rune]int{'十': 10, '百': 100, '千': 1000}
+
+func isKanjiNumeral(r rune) bool {
+	_, digit := kanjiDigits[r]
+	_, unit := kanjiUnits[r]
+	return digit || unit
+}
+
+// KanjiToNumber は「二十三」「百五」「一〇」のような漢数字を数値にする
+func KanjiToNumber(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	total, current := 0, 0
+	positional := true // 「一〇」のような位取り表記
+	for _, r := range s {
+		if _, ok := kanjiUnits[r]; ok {
+			positional = false
+		}
+	}
+	for _, r := range s {
+		if d, ok := kanjiDigits[r]; ok {
+			if positional {
+				current = current*10 + d
+			} else {
+				current = d
+			}
+			continue
+		}
+		unit, ok := kanjiUnits[r]
+		if !ok {
+			return 0, false
+		}
+		if current == 0 {
+			current = 1
+		}
+		total += current * unit
+		current = 0
+	}
+	return total + current, true
+}
+
+// ReplaceKanjiBlockNumbers は住所の丁目・番地・番・号の前の漢数字を算用数字にする
+//
+// 丁目の前は常に変換する。番・番地の前は後ろに番号 (「五番七号」の七) が続く場合か、丁目・番の後に続く場合だけ、
+// 号の前は丁目・番の後に続く場合だけ変換する。
+// 「麻布十番」「麻布十番駅」「一番町」「三番町」「九段」「一ツ橋」「六本木」「八丁堀」などの地名はそのまま。
+func ReplaceKanjiBlockNumbers(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		if !isKanjiNumeral(runes[i]) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isKanjiNumeral(runes[j]) {
+			j++
+		}
+		if blockNumberAt(runes, j, b.String()) {
+			if n, ok := KanjiToNumber(string(runes[i:j])); ok {
+				b.WriteString(strconv.Itoa(n))
+				i = j
+				continue
+			}
+		}
+		b.WriteString(string(runes[i:j]))
+		i = j
+	}
+	return b.String()
+}
+
+// blockNumberAt は runes[j:] が丁目・番地・番・号で始まり、その前の数字が住所の番号かを返す
+// before はそこまでに書いた文字列
+func blockNumberAt(runes []rune, j int, before string) bool {
+	rest := string(runes[j:])
+	switch {
+	case strings.HasPrefix(rest, "丁目"):
+		return true
+	case strings.HasPrefix(rest, "番地"):
+		return followsBlockNumber(before) || startsWithBlockNumber(runes[j+2:])
+	case strings.HasPrefix(rest, "番"):
+		return followsBlockNumber(before) || startsWithBlockNumber(runes[j+1:])
+	case strings.HasPrefix(rest, "号"):
+		return followsBlockNumber(before)
+	}
+	return false
+}
+
+// followsBlockNumber は s が「3丁目」「5番」「5番地」「3-」のような住所の番号で終わるかを返す
+func followsBlockNumber(s string) bool {
+	for _, suffix := range []string{"丁目", "番地", "番", "-"} {
+		if rest, ok := strings.CutSuffix(s, suffix); ok {
+			r := []rune(rest)
+			return len(r) > 0 && unicode.IsDigit(r[len(r)-1])
+		}
+	}
+	return false
+}
+
+// startsWithBlockNumber は runes が番号 (算用数字・漢数字) で始まり、その番号が丁目の番号ではないかを返す
+// 「麻布十番一丁目」の一は丁目の番号なので、その前の十番は地名として残す
+func startsWithBlockNumber(runes []rune) bool {
+	k := 0
+	for k < len(runes) && (unicode.IsDigit(runes[k]) || isKanjiNumeral(runes[k])) {
+		k++
+	}
+	return k > 0 && !strings.HasPrefix(string(runes[k:]), "丁目")
+}
+
+var (
+	// 数字の間のハイフンの異体字と「の」 (3の5)
+	blockSeparatorPattern = regexp.MustCompile(`(\d)\s*[‐‑‒–—―−ーの]\s*(\d)`)
+	chomePattern          = regexp.MustCompile(`(\d+)丁目`)
+	banPattern            = regexp.MustCompile(`(\d+)番(地)?`)
+	goPattern             = regexp.MustCompile(`(\d+)号`)
+)
+
+// FoldBlockNumbers は丁目・番地・号の表記を「3-5-7」の形に揃える
+//
+// 「3丁目5番地7号」「三丁目5番7号」「3-5-7」「3の5の7」は全て「3-5-7」になる。
+// 番・番地は ReplaceKanjiBlockNumbers と同じく番号が続く場合か丁目の後だけ揃え、
+// 「麻布十番」「一番町」「10番町」「麻布十番駅」のような地名は変えない。
+func FoldBlockNumbers(s string) string {
+	s = ReplaceKanjiBlockNumbers(s)
+	for blockSeparatorPattern.MatchString(s) {
+		s = blockSeparatorPattern.ReplaceAllString(s, "$1-$2")
+	}
+	s = chomePattern.ReplaceAllString(s, "$1-")
+	s = replaceBanNumbers(s)
+	s = goPattern.ReplaceAllString(s, "$1")
+	return collapseHyphens(s)
+}
+
+// replaceBanNumbers は住所の番号の「5番」「5番地」を「5-」にする
+func replaceBanNumbers(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range banPattern.FindAllStringIndex(s, -1) {
+		b.WriteString(s[last:m[0]])
+		number := s[m[0]:m[1]]
+		if followsBlockNumber(s[:m[0]]) || startsWithBlockNumber([]rune(s[m[1]:])) {
+			number = banPattern.ReplaceAllString(number, "$1-")
+		}
+		b.WriteString(number)
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
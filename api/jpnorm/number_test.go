@@ -0,0 +1,95 @@
+package jpnorm
+
+import "testing"
+
+func TestKanjiToNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"一", 1, true},
+		{"十", 10, true},
+		{"二十三", 23, true},
+		{"百五", 105, true},
+		{"一〇", 10, true},
+		{"千二百", 1200, true},
+		{"", 0, false},
+		{"丁", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := KanjiToNumber(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("KanjiToNumber(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReplaceKanjiBlockNumbers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		// data/bus_stops.json のバス停名
+		{"東陽六丁目", "東陽6丁目"},
+		{"九段三丁目", "九段3丁目"},
+		{"一之江七丁目", "一之江7丁目"},
+		{"三ノ輪二丁目", "三ノ輪2丁目"},
+		{"上十条五丁目", "上十条5丁目"},
+		{"八丁堀二丁目", "八丁堀2丁目"},
+		{"六本木一丁目駅前", "六本木1丁目駅前"},
+		{"北砂五丁目団地", "北砂5丁目団地"},
+		{"千石一丁目(せんごくいっちょうめ（こうとうく）)", "千石1丁目(せんごくいっちょうめ（こうとうく）)"},
+		// 番号の付いた地名は変えない
+		{"麻布十番", "麻布十番"},
+		{"麻布十番駅", "麻布十番駅"},
+		{"麻布十番駅前", "麻布十番駅前"},
+		{"麻布十番一丁目", "麻布十番1丁目"},
+		{"小川一番", "小川一番"},
+		{"番町", "番町"},
+		{"千代田区一番町", "千代田区一番町"},
+		{"三番町", "三番町"},
+		{"九段下", "九段下"},
+		{"九段上", "九段上"},
+		{"一ツ橋", "一ツ橋"},
+		{"六本木", "六本木"},
+		{"八丁堀", "八丁堀"},
+		{"国道二十号", "国道二十号"},
+		// 住所の番地・号
+		{"永田町一丁目五番地", "永田町1丁目5番地"},
+		{"永田町一丁目五番七号", "永田町1丁目5番7号"},
+		{"五番七号", "5番7号"},
+		{"三番地12", "3番地12"},
+		{"三丁目5番", "3丁目5番"},
+	}
+	for _, tt := range tests {
+		if got := ReplaceKanjiBlockNumbers(tt.in); got != tt.want {
+			t.Errorf("ReplaceKanjiBlockNumbers(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFoldBlockNumbers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"3丁目5番地7号", "3-5-7"},
+		{"三丁目5番7号", "3-5-7"},
+		{"3-5-7", "3-5-7"},
+		{"3の5の7", "3-5-7"},
+		{"永田町1-5番", "永田町1-5-"},
+		{"東陽六丁目", "東陽6-"},
+		{"麻布十番", "麻布十番"},
+		{"麻布十番駅", "麻布十番駅"},
+		{"一番町", "一番町"},
+		{"10番町", "10番町"},
+		{"麻布10番", "麻布10番"},
+		{"九段", "九段"},
+	}
+	for _, tt := range tests {
+		if got := FoldBlockNumbers(tt.in); got != tt.want {
+			t.Errorf("FoldBlockNumbers(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package jpnorm
+
+import (
+	"strings"
+)
+
+// ヘボン式・訓令式・ワープロ入力のローマ字とカタカナの対応
+var romajiTable = map[string]string{
+	"a": "ア", "i": "イ", "u": "ウ", "e": "エ", "o": "オ",
+	"ka": "カ", "ki": "キ", "ku": "ク", "ke": "ケ", "ko": "コ",
+	"sa": "サ", "shi": "シ", "si": "シ", "su": "ス", "se": "セ", "so": "ソ",
+	"ta": "タ", "chi": "チ", "ti": "チ", "tsu": "ツ", "tu": "ツ", "te": "テ", "to": "ト",
+	"na": "ナ", "ni": "ニ", "nu": "ヌ", "ne": "ネ", "no": "ノ",
+	"ha": "ハ", "hi": "ヒ", "fu": "フ", "hu": "フ", "he": "ヘ", "ho": "ホ",
+	"ma": "マ", "mi": "ミ", "mu": "ム", "me": "メ", "mo": "モ",
+	"ya": "ヤ", "yu": "ユ", "yo": "ヨ",
+	"ra": "ラ", "ri": "リ", "ru": "ル", "re": "レ", "ro": "ロ",
+	"la": "ラ", "li": "リ", "lu": "ル", "le": "レ", "lo": "ロ",
+	"wa": "ワ", "wi": "ウィ", "we": "ウェ", "wo": "ヲ",
+	"ga": "ガ", "gi": "ギ", "gu": "グ", "ge": "ゲ", "go": "ゴ",
+	"za": "ザ", "ji": "ジ", "zi": "ジ", "zu": "ズ", "ze": "ゼ", "zo": "ゾ",
+	"da": "ダ", "di": "ヂ", "du": "ヅ", "de": "デ", "do": "ド",
+	"ba": "バ", "bi": "ビ", "bu": "ブ", "be": "ベ", "bo": "ボ",
+	"pa": "パ", "pi": "ピ", "pu": "プ", "pe": "ペ", "po": "ポ",
+	"va": "ヴァ", "vi": "ヴィ", "vu": "ヴ", "ve": "ヴェ", "vo": "ヴォ",
+	"fa": "ファ", "fi": "フィ", "fe": "フェ", "fo": "フォ",
+	"kya": "キャ", "kyu": "キュ", "kyo": "キョ",
+	"sha": "シャ", "shu": "シュ", "she": "シェ", "sho": "ショ",
+	"sya": "シャ", "syu": "シュ", "syo": "ショ",
+	"cha": "チャ", "chu": "チュ", "che": "チェ", "cho": "チョ",
+	"tya": "チャ", "tyu": "チュ", "tyo": "チョ",
+	"cya": "チャ", "cyu": "チュ", "cyo": "チョ",
+	"nya": "ニャ", "nyu": "ニュ", "nyo": "ニョ",
+	"hya": "ヒャ", "hyu": "ヒュ", "hyo": "ヒョ",
+	"mya": "ミャ", "myu": "ミュ", "myo": "ミョ",
+	"rya": "リャ", "ryu": "リュ", "ryo": "リョ",
+	"gya": "ギャ", "gyu": "ギュ", "gyo": "ギョ",
+	"ja": "ジャ", "ju": "ジュ", "je": "ジェ", "jo": "ジョ",
+	"jya": "ジャ", "jyu": "ジュ", "jyo": "ジョ",
+	"zya": "ジャ", "zyu": "ジュ", "zyo": "ジョ",
+	"dya": "ヂャ", "dyu": "ヂュ", "dyo": "ヂョ",
+	"bya": "ビャ", "byu": "ビュ", "byo": "ビョ",
+	"pya": "ピャ", "pyu": "ピュ", "pyo": "ピョ",
+	"-": "ー",
+}
+
+// 長音符付きのローマ字 (Tōkyō) は母音を重ねる
+var macronReplacer = strings.NewReplacer(
+	"ā", "aa", "ī", "ii", "ū", "uu", "ē", "ee", "ō", "ou",
+	"â", "aa", "î", "ii", "û", "uu", "ê", "ee", "ô", "ou",
+)
+
+// RomajiToKatakana はローマ字をカタカナに変換する
+// ローマ字として読めない文字が含まれる場合は false を返す
+func RomajiToKatakana(s string) (string, bool) {
+	s = macronReplacer.Replace(strings.ToLower(s))
+	s = strings.ReplaceAll(s, " ", "")
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		// 撥音: nn, n', 子音の前や末尾の n
+		if c == 'n' {
+			if i+1 == len(s) {
+				b.WriteString("ン")
+				i++
+				continue
+			}
+			next := s[i+1]
+			if next == '\'' || next == 'n' {
+				b.WriteString("ン")
+				i += 2
+				continue
+			}
+			if !strings.ContainsRune("aiueoy", rune(next)) {
+				b.WriteString("ン")
+				i++
+				continue
+			}
+		}
+		// ヘボン式の b, p, m の前の m (shimbashi)
+		if c == 'm' && i+1 < len(s) && strings.ContainsRune("bpm", rune(s[i+1])) {
+			b.WriteString("ン")
+			i++
+			continue
+		}
+		// 促音: 同じ子音の連続 (tch も含む)
+		if i+1 < len(s) && c != 'n' && strings.ContainsRune("bcdfghjklmpqrstvwxyz", rune(c)) &&
+			(s[i+1] == c || (c == 't' && s[i+1] == 'c')) {
+			b.WriteString("ッ")
+			i++
+			continue
+		}
+		matched := false
+		for size := 3; size >= 1; size-- {
+			if i+size > len(s) {
+				continue
+			}
+			if kana, ok := romajiTable[s[i:i+size]]; ok {
+				b.WriteString(kana)
+				i += size
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// IsRomaji はローマ字入力らしい文字列(英字と空白・ハイフン・アポストロフィのみ)かを返す
+func IsRomaji(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range macronReplacer.Replace(strings.ToLower(s)) {
+		if !(r >= 'a' && r <= 'z') && r != ' ' && r != '-' && r != '\'' {
+			return false
+		}
+	}
+	return true
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -23,7 +23,11 @@ import (
 // @BasePath /api/v1
 
 func main() {
-	loadWarningIntersection()
+	// go run . geocode ... は一括ジオコーディングのサブコマンド (.env は任意)
+	if len(os.Args) > 1 && os.Args[1] == "geocode" {
+		godotenv.Load()
+		os.Exit(runGeocodeCommand(os.Args[2:]))
+	}
 
 	// Load environment variables from .env file
 	err := godotenv.Load()
@@ -31,13 +35,32 @@ func main() {
 		panic("Error loading .env file")
 	}
 
+	// データセットを読み込み、ファイルが変わったら読み込み直す
+	util.LoadDatasets()
+	go util.WatchDatasets(context.Background())
+
+	// 名前の無い危険箇所に逆ジオコーディングで名前を付ける
+	go util.NameHazards(context.Background())
+
 	r := gin.Default()
+	// X-Forwarded-For は TRUSTED_PROXIES (カンマ区切りの IP・CIDR) からの接続の場合だけ信じる
+	// 設定しない場合は接続元の IP を使う (報告の自動承認・投票の接続元の判定に使う)
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		panic("Invalid TRUSTED_PROXIES: " + err.Error())
+	}
 
 	// Add CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Expose-Headers", "X-Total-Count, Retry-After")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -56,29 +79,45 @@ func main() {
 		v1.GET("/health", getHealth)
 		// 経路検索
 		v1.GET("/directions/bicycle", util.GetDirections)
+		// 複数の立ち寄り先の巡回ルート
+		v1.POST("/errands/bicycle", util.GetErrandsRoute)
+		// ルート沿いの立ち寄り先
+		v1.GET("/sessions/:id/pois", util.GetSessionPOIs)
+		// グループライドの集合場所
+		v1.POST("/meeting_point", util.GetMeetingPoint)
+		// 所要時間・距離行列
+		v1.POST("/matrix/bicycle", util.GetBicycleMatrix)
+		// ライダープロファイル
+		v1.GET("/profiles", util.GetRiderProfiles)
 		// 目的地検索
 		v1.GET("/search", util.GetSearch)
+		// 目的地の入力補完
+		v1.GET("/autocomplete", util.GetAutocomplete)
+		// 一括ジオコーディング
+		v1.POST("/geocode/batch", util.GetGeocodeBatch)
+		// 逆ジオコーディング
+		v1.GET("/reverse", util.GetReverse)
 		//注意点
 		v1.GET("/warning_point", util.GetWarningPoints)
 		//違反率
 		v1.GET("/violation_rates", util.GetViolationRates)
+		// 利用者の危険箇所の報告
+		v1.POST("/reports", util.PostReport)
+		v1.GET("/reports", util.GetReports)
+		v1.GET("/reports/:id", util.GetReport)
+		v1.POST("/reports/:id/votes", util.PostReportVote)
+		v1.PUT("/reports/:id/moderation", util.RequireAdmin(), util.PutReportModeration)
+		// 期間・時間帯のある通行規制
+		v1.GET("/restrictions", util.GetRestrictions)
+		v1.POST("/restrictions", util.RequireAdmin(), util.PostRestriction)
+		v1.PUT("/restrictions", util.RequireAdmin(), util.ImportRestrictions)
+		v1.PUT("/restrictions/:id", util.RequireAdmin(), util.PutRestriction)
+		v1.DELETE("/restrictions/:id", util.RequireAdmin(), util.DeleteRestriction)
+		// データセットの出典・読み込み直し
+		v1.GET("/datasets", util.GetDatasets)
+		v1.POST("/datasets/reload", util.RequireAdmin(), util.PostDatasetsReload)
 	}
 
 	// Start server on port 8080
 	r.Run("0.0.0.0:8080")
 }
-
-func loadWarningIntersection() {
-	warningIntersectionFile, err := os.Open("warningIntersection.json")
-	if err != nil {
-		fmt.Println("ファイルオープンエラー:", err)
-		return
-	}
-
-	decoder := json.NewDecoder(warningIntersectionFile)
-	if err := decoder.Decode(&util.WorningIntersectionPoints); err != nil {
-		fmt.Println("JSONデコードエラー:", err)
-		return
-	}
-	defer warningIntersectionFile.Close()
-}
@@ -0,0 +1,67 @@
+package osm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskCache はレスポンスのボディをファイルに保存するキャッシュ
+// プロセスを再起動しても同じ問い合わせを外部に送らないようにする
+type diskCache struct {
+	dir string
+	ttl time.Duration
+}
+
+func cacheKey(method, rawURL string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + rawURL + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *diskCache) path(key string) string {
+	// 1つのディレクトリにファイルが集中しないよう先頭2文字で分ける
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+func (c *diskCache) get(key string) ([]byte, bool) {
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		os.Remove(path)
+		return nil, false
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+// set は一時ファイルに書いてから置き換え、読み込み中に壊れたファイルが見えないようにする
+func (c *diskCache) set(key string, body []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
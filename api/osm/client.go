@@ -0,0 +1,279 @@
+// Package osm は Nominatim・Overpass などの OpenStreetMap のサービスを利用規約に沿って使うためのクライアント
+//
+// API サーバーと prepare-data のツールで共有し、次のことを行う。
+//
+//   - アプリ名と連絡先を名乗る User-Agent を付ける (ブラウザを装わない)
+//   - ホストごとに最低間隔(デフォルト1秒)を空けて送信する
+//   - 成功したレスポンスをディスクにキャッシュし、同じリクエストを繰り返さない
+//   - 429/503 の Retry-After に従って待ってから再送する
+package osm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"template-mobile-app-api/httpclient"
+)
+
+// Doer はリクエストを送る HTTP クライアント (httpclient.Client)
+type Doer interface {
+	Do(ctx context.Context, r *httpclient.Request) (*httpclient.Response, error)
+}
+
+// Config はクライアントの設定
+type Config struct {
+	UserAgent     string        // アプリ名/バージョン
+	Email         string        // 連絡先 (User-Agent と Nominatim の email パラメータに使う)
+	MinInterval   time.Duration // 同じホストへのリクエストの最低間隔
+	CacheDir      string        // レスポンスのキャッシュの保存先 (空ならキャッシュしない)
+	CacheTTL      time.Duration // キャッシュの有効期間
+	MaxRetries    int           // 429/503 を受けたときの再送回数
+	MaxRetryAfter time.Duration // Retry-After で待つ時間の上限 (これより長い場合は待たずにエラーにする)
+}
+
+// ConfigFromEnv は環境変数から設定を作る
+func ConfigFromEnv() Config {
+	return Config{
+		UserAgent:     getEnvString("OSM_USER_AGENT", httpclient.DefaultUserAgent),
+		Email:         os.Getenv("OSM_CONTACT_EMAIL"),
+		MinInterval:   getEnvDuration("OSM_MIN_INTERVAL", time.Second),
+		CacheDir:      getEnvString("OSM_CACHE_DIR", "cache/osm"),
+		CacheTTL:      getEnvDuration("OSM_CACHE_TTL", 7*24*time.Hour),
+		MaxRetries:    2,
+		MaxRetryAfter: getEnvDuration("OSM_MAX_RETRY_AFTER", 2*time.Minute),
+	}
+}
+
+// StatusError は 200 以外のレスポンス
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("osm service returned status %d", e.StatusCode)
+}
+
+// ErrRetryAfterTooLong は Retry-After が MaxRetryAfter より長いため待たなかった場合のエラー
+var ErrRetryAfterTooLong = errors.New("retry-after exceeds the maximum wait")
+
+// Client は OpenStreetMap のサービス用のクライアント
+type Client struct {
+	config Config
+	doer   Doer
+	cache  *diskCache
+
+	mu   sync.Mutex
+	next map[string]time.Time // ホストごとの次に送信してよい時刻
+}
+
+// New は Client を作成する
+func New(config Config, doer Doer) *Client {
+	c := &Client{
+		config: config,
+		doer:   doer,
+		next:   map[string]time.Time{},
+	}
+	if config.CacheDir != "" {
+		c.cache = &diskCache{dir: config.CacheDir, ttl: config.CacheTTL}
+	}
+	return c
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default は環境変数から設定した共有クライアントを返す
+func Default() *Client {
+	defaultOnce.Do(func() {
+		defaultClient = New(ConfigFromEnv(), httpclient.Default())
+	})
+	return defaultClient
+}
+
+// NewTool は prepare-data のツール用の Client を作成する
+// Overpass の重い問い合わせに合わせて 1回の試行のタイムアウトを timeout にする
+func NewTool(timeout time.Duration) *Client {
+	config := ConfigFromEnv()
+	return New(config, httpclient.New(httpclient.Config{
+		Timeout:          timeout,
+		MaxRetries:       2,
+		BaseBackoff:      time.Second,
+		MaxBackoff:       10 * time.Second,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+		UserAgent:        config.UserAgent,
+	}))
+}
+
+// UserAgent は送信する User-Agent を返す (例: template-mobile-app-api/1.0 (contact: dev@example.com))
+func (c *Client) UserAgent() string {
+	if c.config.Email == "" {
+		return c.config.UserAgent
+	}
+	return fmt.Sprintf("%s (contact: %s)", c.config.UserAgent, c.config.Email)
+}
+
+// Get は GET リクエストを送る
+func (c *Client) Get(ctx context.Context, rawURL string) ([]byte, error) {
+	return c.do(ctx, &httpclient.Request{Method: http.MethodGet, URL: c.withEmail(rawURL)})
+}
+
+// Cached は GET のレスポンスがキャッシュにあれば返す (送信はしない)
+// 呼び出し側のレート制限の予算をキャッシュのヒットで使わないために、送る前に確かめる
+func (c *Client) Cached(rawURL string) ([]byte, bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+	return c.cache.get(cacheKey(http.MethodGet, c.withEmail(rawURL), nil))
+}
+
+// withEmail は Nominatim には連絡先を email パラメータでも渡す
+func (c *Client) withEmail(rawURL string) string {
+	if c.config.Email != "" && strings.Contains(rawURL, "nominatim") {
+		if u, err := url.Parse(rawURL); err == nil {
+			q := u.Query()
+			if q.Get("email") == "" {
+				q.Set("email", c.config.Email)
+				u.RawQuery = q.Encode()
+				return u.String()
+			}
+		}
+	}
+	return rawURL
+}
+
+// PostForm はフォームを POST する (Overpass API)
+// Overpass の問い合わせは参照のみなので冪等として扱う
+func (c *Client) PostForm(ctx context.Context, rawURL string, form url.Values) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	return c.do(ctx, &httpclient.Request{
+		Method:     http.MethodPost,
+		URL:        rawURL,
+		Header:     header,
+		Body:       []byte(form.Encode()),
+		Idempotent: true,
+	})
+}
+
+func (c *Client) do(ctx context.Context, r *httpclient.Request) ([]byte, error) {
+	key := cacheKey(r.Method, r.URL, r.Body)
+	if c.cache != nil {
+		if body, ok := c.cache.get(key); ok {
+			return body, nil
+		}
+	}
+
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	host := u.Host
+
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Set("User-Agent", c.UserAgent())
+	r.BeforeRetry = func(ctx context.Context) error { return c.wait(ctx, host) }
+	// 503 は httpclient でリトライせず、ここで Retry-After に従って再送する
+	r.HandleUnavailable = true
+
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx, host); err != nil {
+			return nil, err
+		}
+		resp, err := c.doer.Do(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			if c.cache != nil {
+				if err := c.cache.set(key, resp.Body); err != nil {
+					fmt.Println("osm cache write error:", err)
+				}
+			}
+			return resp.Body, nil
+		}
+
+		// 429/503 は Retry-After の間このホストへの送信を止めて再送する
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return nil, &StatusError{StatusCode: resp.StatusCode, Body: resp.Body}
+		}
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), c.config.MinInterval)
+		c.delay(host, retryAfter)
+		if retryAfter > c.config.MaxRetryAfter {
+			return nil, fmt.Errorf("%w: %s", ErrRetryAfterTooLong, retryAfter)
+		}
+		if attempt >= c.config.MaxRetries {
+			return nil, &StatusError{StatusCode: resp.StatusCode, Body: resp.Body}
+		}
+	}
+}
+
+// wait はホストごとの最低間隔と Retry-After を守って送信の順番を待つ
+func (c *Client) wait(ctx context.Context, host string) error {
+	c.mu.Lock()
+	next := c.next[host]
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	c.next[host] = next.Add(c.config.MinInterval)
+	c.mu.Unlock()
+
+	if d := time.Until(next); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// delay はホストへの次の送信を d 後以降にする
+func (c *Client) delay(host string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until := time.Now().Add(d); c.next[host].Before(until) {
+		c.next[host] = until
+	}
+}
+
+// parseRetryAfter は Retry-After (秒数または日時) を待ち時間にする
+func parseRetryAfter(value string, def time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return def
+}
+
+func getEnvString(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,172 @@
+// Package provenance はデータセットの出典を記録するサイドカー (<データのファイル>.meta.json) を読み書きする
+//
+// prepare-data のツールがデータを書いた後に Write でサイドカーを書き、API サーバーはデータと一緒に Read で読み込んで
+// /api/v1/datasets とクレジット表示用の出典を返す。
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// Source はデータの出典とライセンス
+type Source struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	License     string `json:"license"`
+	LicenseURL  string `json:"license_url,omitempty"`
+	Attribution string `json:"attribution"` // クレジット表示に使う文言
+}
+
+// Metadata はサイドカーに書くデータセットの出典
+type Metadata struct {
+	Dataset     string    `json:"dataset"`             // データのファイル名
+	SourceURL   string    `json:"source_url"`          // 取得に使った URL (API・ファイル・ディレクトリ)
+	Sources     []Source  `json:"sources"`             // 出典 (座標を求めるのに使ったサービスも含む)
+	FetchedAt   time.Time `json:"fetched_at,omitzero"` // 出典からデータを取得した日時 (分からない場合は省く)
+	Records     int       `json:"records"`
+	Tool        string    `json:"tool"` // データを作ったツール
+	ToolVersion string    `json:"tool_version"`
+	SHA256      string    `json:"sha256"` // データのファイルのチェックサム
+	// データを作った後でサイドカーだけ書いたもの。取得日時とツールのバージョンは分からない
+	Backfilled bool `json:"backfilled,omitempty"`
+}
+
+// よく使う出典
+var (
+	OpenStreetMap = Source{
+		Name:        "OpenStreetMap",
+		URL:         "https://www.openstreetmap.org/copyright",
+		License:     "ODbL-1.0",
+		LicenseURL:  "https://opendatacommons.org/licenses/odbl/1-0/",
+		Attribution: "© OpenStreetMap contributors",
+	}
+	OpenRouteService = Source{
+		Name:        "openrouteservice",
+		URL:         "https://openrouteservice.org/",
+		License:     "openrouteservice Terms of Service",
+		LicenseURL:  "https://openrouteservice.org/terms-of-service/",
+		Attribution: "© openrouteservice.org by HeiGIT | Map data © OpenStreetMap contributors",
+	}
+	// 都営バスのバス停 (公共交通オープンデータセンター)
+	ODPTToei = Source{
+		Name:        "公共交通オープンデータセンター (東京都交通局)",
+		URL:         "https://www.odpt.org/",
+		License:     "CC-BY-4.0",
+		LicenseURL:  "https://creativecommons.org/licenses/by/4.0/deed.ja",
+		Attribution: "出典: 東京都交通局・公共交通オープンデータ協議会。本アプリケーションが利用する公共交通データは、公共交通オープンデータセンターにおいて提供されるものです。公共交通事業者により提供されたデータを元にしていますが、必ずしも正確・完全なものとは限りません。本アプリケーションの表示内容について、公共交通事業者への直接の問合せは行わないでください。",
+	}
+	// 東京都オープンデータカタログサイトのデータ (取締強化交差点・交通量統計表)
+	TokyoOpenData = Source{
+		Name:        "東京都オープンデータカタログサイト",
+		URL:         "https://catalog.data.metro.tokyo.lg.jp/",
+		License:     "CC-BY-4.0",
+		LicenseURL:  "https://creativecommons.org/licenses/by/4.0/deed.ja",
+		Attribution: "出典: 東京都オープンデータカタログサイト (警視庁)",
+	}
+	// 警察庁 交通事故統計情報のオープンデータ
+	NPATrafficAccidents = Source{
+		Name:        "警察庁 交通事故統計情報のオープンデータ",
+		URL:         "https://www.npa.go.jp/publications/statistics/koutsuu/opendata/index_opendata.html",
+		License:     "公共データ利用規約 (第1.0版)",
+		LicenseURL:  "https://www.digital.go.jp/resources/open_data/public_data_license_v1.0",
+		Attribution: "出典: 警察庁「交通事故統計情報のオープンデータ」を加工して作成",
+	}
+	// 国土地理院 住所検索API (取締強化交差点の座標を求めるのに使う)
+	GSI = Source{
+		Name:        "国土地理院",
+		URL:         "https://msearch.gsi.go.jp/address-search/AddressSearch",
+		License:     "国土地理院コンテンツ利用規約",
+		LicenseURL:  "https://www.gsi.go.jp/kikakuchousei/kikakuchousei40182.html",
+		Attribution: "出典: 国土地理院",
+	}
+)
+
+// SidecarPath はデータのファイルのサイドカーのパスを返す
+func SidecarPath(path string) string {
+	return path + ".meta.json"
+}
+
+// Write は path に書いたデータのサイドカーを書く
+// SHA256 は path から計算し、Tool・ToolVersion が空の場合は実行中のツールのビルド情報を使う
+func Write(path string, meta Metadata) error {
+	sum, err := Checksum(path)
+	if err != nil {
+		return err
+	}
+	meta.SHA256 = sum
+	if meta.Tool == "" || meta.ToolVersion == "" {
+		tool, version := toolInfo()
+		if meta.Tool == "" {
+			meta.Tool = tool
+		}
+		if meta.ToolVersion == "" {
+			meta.ToolVersion = version
+		}
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(SidecarPath(path), append(data, '\n'), 0o644)
+}
+
+// Read は path のデータのサイドカーを読み込む
+// サイドカーが無い場合は os.ErrNotExist を返す
+func Read(path string) (Metadata, error) {
+	var meta Metadata
+	data, err := os.ReadFile(SidecarPath(path))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("%s: %w", SidecarPath(path), err)
+	}
+	return meta, nil
+}
+
+// Checksum はファイルの SHA-256 を16進数で返す
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ChecksumBytes は読み込み済みのデータの SHA-256 を16進数で返す
+func ChecksumBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// toolInfo は実行中のツールのパッケージとバージョン (git のリビジョンが分かればそれも) を返す
+func toolInfo() (tool, version string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown", "unknown"
+	}
+	tool, version = info.Path, info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			version += "+" + s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				version += "-dirty"
+			}
+		}
+	}
+	return tool, version
+}
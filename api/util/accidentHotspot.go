@@ -0,0 +1,89 @@
+package util
+
+import (
+	"fmt"
+)
+
+// 自転車事故の多発地点の注意点の種類
+const AccidentHotspotType = "accident_hotspot"
+
+// AccidentStats は事故多発地点の事故の内訳
+type AccidentStats struct {
+	Count     int            `json:"count"`       // 事故件数
+	Fatal     int            `json:"fatal"`       // 死亡事故
+	Serious   int            `json:"serious"`     // 重傷事故
+	Minor     int            `json:"minor"`       // 軽傷事故
+	TimeOfDay map[string]int `json:"time_of_day"` // 時間帯別の件数 (morning: 6-10時, daytime: 10-16時, evening: 16-20時, night: 20-6時)
+	Years     []int          `json:"years,omitempty"`
+}
+
+// AccidentHotspot は prepare-data/prepare_accident で交通事故統計から作成した自転車事故の多発地点
+type AccidentHotspot struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	Coordinate []float64 `json:"coordinate"` // [経度, 緯度]
+	AccidentStats
+}
+
+// accidentHotspots は事故多発地点データ(ACCIDENT_HOTSPOTS_FILE, デフォルト data/accident_hotspots.json)
+// ファイルが無い場合は空として扱う
+var accidentHotspots = newDataset("accident_hotspots", "ACCIDENT_HOTSPOTS_FILE", "data/accident_hotspots.json", parseJSONDataset(func(h AccidentHotspot) error {
+	if h.Count < 0 || h.Fatal < 0 || h.Serious < 0 || h.Minor < 0 {
+		return fmt.Errorf("accident counts must not be negative")
+	}
+	return validateCoordinate(h.Coordinate)
+}))
+
+// AccidentHotspots は読み込み済みの事故多発地点を返す
+func AccidentHotspots() []AccidentHotspot {
+	return accidentHotspots.Get()
+}
+
+// WarningPoint は事故多発地点を注意点にする (メッセージは defaultMessageLanguage)
+func (h AccidentHotspot) WarningPoint() WarningPoint {
+	stats := h.AccidentStats
+	return WarningPoint{
+		Type:       AccidentHotspotType,
+		Name:       h.Name,
+		Coordinate: h.Coordinate,
+		Message:    accidentHotspotMessage(hazardMessages.get(), defaultMessageLanguage, h.Coordinate, stats),
+		Accident:   &stats,
+	}
+}
+
+// accidentHotspotMessage は「2019-2023年に自転車が関係する事故が5件 (うち死亡1件・重傷2件)。夕方 (16-20時) に多く発生」のようなメッセージを
+// accident_hotspot のテンプレートから作る。危険度は死亡事故があれば high、重傷事故があれば medium
+func accidentHotspotMessage(catalog *hazardMessageCatalog, lang string, coordinate []float64, stats AccidentStats) string {
+	values := map[string]string{
+		"count":   fmt.Sprint(stats.Count),
+		"fatal":   countValue(stats.Fatal),
+		"serious": countValue(stats.Serious),
+	}
+	if len(stats.Years) > 0 {
+		first, last := stats.Years[0], stats.Years[len(stats.Years)-1]
+		if first == last {
+			values["years"] = fmt.Sprint(first)
+		} else {
+			values["years"] = fmt.Sprintf("%d-%d", first, last)
+		}
+	}
+
+	// 件数が最も多い時間帯 (半数以上を占める場合だけ)
+	peak, peakCount := "", 0
+	for _, key := range []string{"morning", "daytime", "evening", "night"} {
+		if n := stats.TimeOfDay[key]; n > peakCount {
+			peak, peakCount = key, n
+		}
+	}
+	if peak != "" && peakCount*2 >= stats.Count {
+		values["peak"] = catalog.label(lang, peak)
+	}
+
+	tier := RiskLow
+	if stats.Fatal > 0 {
+		tier = RiskHigh
+	} else if stats.Serious > 0 {
+		tier = RiskMedium
+	}
+	return catalog.message(lang, AccidentHotspotType, tier, hazardMessageKey(coordinate), values)
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isAdmin は Authorization: Bearer <ADMIN_TOKEN> が付いているかを返す
+// ADMIN_TOKEN が設定されていない場合は管理者として扱わない
+func isAdmin(c *gin.Context) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return false
+	}
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// RequireAdmin は管理者用のAPIに付けるミドルウェア
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv("ADMIN_TOKEN") == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "Admin API disabled", Message: "ADMIN_TOKEN is not set"})
+			return
+		}
+		if !isAdmin(c) {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized", Message: "a valid admin bearer token is required"})
+			return
+		}
+		c.Next()
+	}
+}
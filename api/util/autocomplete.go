@@ -0,0 +1,281 @@
+package util
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"template-mobile-app-api/jpnorm"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAutocompleteLimit = 8
+	maxAutocompleteLimit     = 20
+	// 並べ替えの前に集める候補の上限 (短い入力で辞書全体を並べ替えないように)
+	maxAutocompleteCandidates = 500
+)
+
+// AutocompleteSuggestion は入力補完の候補
+// 選ばれた候補は Query を /search に渡して確定する
+type AutocompleteSuggestion struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Category   string    `json:"category"`
+	Coordinate []float64 `json:"coordinate"`         // [経度, 緯度]
+	Query      string    `json:"query"`              // /search に渡す検索文字列
+	Source     string    `json:"source"`             // gazetteer: 地名辞書, recent: 最近検索された地点
+	Distance   float64   `json:"distance,omitempty"` // lat, lon を指定した場合のその地点からの距離(m)
+}
+
+// GetAutocomplete godoc
+// @Summary 目的地の入力補完
+// @Description 入力途中の文字列に前方一致する地名を、ローカルの地名辞書と最近検索された地点から返す。外部APIには問い合わせないので入力のたびに呼んでよい。選んだ候補は query を /search に渡して確定する
+// @Tags map
+// @Accept json
+// @Produce json
+// @Param q query string true "入力途中の文字列 (かな・ローマ字も可)"
+// @Param lat query number false "ライダーの現在地の緯度 (近い順に並べる)"
+// @Param lon query number false "ライダーの現在地の経度 (近い順に並べる)"
+// @Param limit query int false "件数の上限 (1-20, デフォルト8)"
+// @Success 200 {object} []AutocompleteSuggestion
+// @Failure 400 {object} ErrorResponse
+// @Router /autocomplete [get]
+func GetAutocomplete(c *gin.Context) {
+	var focus *Coordinate
+	if c.Query("lat") != "" || c.Query("lon") != "" {
+		coordinate, err := ParseCoordinate(c.Query("lon") + "," + c.Query("lat"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid coordinate", Message: err.Error()})
+			return
+		}
+		focus = &coordinate
+	}
+	limit := defaultAutocompleteLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAutocompleteLimit {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid limit", Message: "limit must be between 1 and 20"})
+			return
+		}
+		limit = n
+	}
+	c.JSON(http.StatusOK, Autocomplete(c.Query("q"), focus, limit))
+}
+
+// Autocomplete は入力途中の文字列に前方一致する候補を返す
+//
+// 最近検索された地点 → 名前の前方一致 → 読みの前方一致の順に優先し、
+// 同じ優先度の中では focus に近いもの、名前の短いものを先にする。
+func Autocomplete(input string, focus *Coordinate, limit int) []AutocompleteSuggestion {
+	folded := jpnorm.Fold(input)
+	suggestions := []AutocompleteSuggestion{}
+	if folded == "" {
+		return suggestions
+	}
+	var reading string
+	if jpnorm.IsKana(folded) {
+		reading = jpnorm.LooseKana(folded)
+	} else if jpnorm.IsRomaji(input) {
+		if kana, ok := jpnorm.RomajiToKatakana(input); ok {
+			reading = jpnorm.LooseKana(kana)
+		}
+	}
+
+	type candidate struct {
+		suggestion AutocompleteSuggestion
+		priority   int
+	}
+	var candidates []candidate
+	seen := map[string]bool{}
+	add := func(s AutocompleteSuggestion, priority int) {
+		if seen[s.ID] || len(s.Coordinate) != 2 {
+			return
+		}
+		seen[s.ID] = true
+		if focus != nil {
+			s.Distance = math.Round(haversineMeters(focus[:], s.Coordinate))
+		}
+		candidates = append(candidates, candidate{s, priority})
+	}
+
+	for _, p := range recentPlaces.prefix(folded, reading) {
+		add(p, 0)
+	}
+	index := loadAutocompleteIndex()
+	for _, i := range index.names.prefix(folded, maxAutocompleteCandidates) {
+		add(index.suggestion(i), 1)
+	}
+	if reading != "" {
+		for _, i := range index.readings.prefix(reading, maxAutocompleteCandidates) {
+			add(index.suggestion(i), 2)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		if a.suggestion.Distance != b.suggestion.Distance {
+			return a.suggestion.Distance < b.suggestion.Distance
+		}
+		return len(a.suggestion.Name) < len(b.suggestion.Name)
+	})
+	for _, c := range candidates {
+		if len(suggestions) >= limit {
+			break
+		}
+		suggestions = append(suggestions, c.suggestion)
+	}
+	return suggestions
+}
+
+// =================前方一致の索引=================
+
+// prefixIndex は正規化したキーを並べた索引で、二分探索で前方一致を探す
+type prefixIndex struct {
+	keys    []string
+	entries []int // keys と同じ順の地名辞書のインデックス
+}
+
+func (p *prefixIndex) Len() int           { return len(p.keys) }
+func (p *prefixIndex) Less(i, j int) bool { return p.keys[i] < p.keys[j] }
+func (p *prefixIndex) Swap(i, j int) {
+	p.keys[i], p.keys[j] = p.keys[j], p.keys[i]
+	p.entries[i], p.entries[j] = p.entries[j], p.entries[i]
+}
+
+// prefix は key が prefix で始まる地名辞書のインデックスを最大 limit 件返す
+func (p *prefixIndex) prefix(prefix string, limit int) []int {
+	var result []int
+	for i := sort.SearchStrings(p.keys, prefix); i < len(p.keys) && len(result) < limit; i++ {
+		if !strings.HasPrefix(p.keys[i], prefix) {
+			break
+		}
+		result = append(result, p.entries[i])
+	}
+	return result
+}
+
+// autocompleteIndex は地名辞書の名前・別名と読みの前方一致の索引
+type autocompleteIndex struct {
+	gazetteer *gazetteerIndex
+	names     prefixIndex
+	readings  prefixIndex
+}
+
+// 地名辞書と同じく、データセットを読み込み直したら次に使うときに作り直す
+var autocomplete = newDerived(func() *autocompleteIndex {
+	g := loadGazetteer()
+	index := &autocompleteIndex{gazetteer: g}
+	for i := range g.entries {
+		for _, key := range g.keys[i] {
+			index.names.keys = append(index.names.keys, key)
+			index.names.entries = append(index.names.entries, i)
+		}
+		for _, key := range g.readings[i] {
+			index.readings.keys = append(index.readings.keys, key)
+			index.readings.entries = append(index.readings.entries, i)
+		}
+	}
+	sort.Sort(&index.names)
+	sort.Sort(&index.readings)
+	return index
+})
+
+func loadAutocompleteIndex() *autocompleteIndex {
+	return autocomplete.get()
+}
+
+func (a *autocompleteIndex) suggestion(i int) AutocompleteSuggestion {
+	e := a.gazetteer.entries[i]
+	return AutocompleteSuggestion{
+		ID:         e.ID,
+		Name:       e.Name,
+		Category:   e.Category,
+		Coordinate: e.Coordinate,
+		Query:      e.Name,
+		Source:     "gazetteer",
+	}
+}
+
+// =================最近検索された地点=================
+
+// recentPlaceStore は /search で確定した地点を新しい順に保持する
+type recentPlaceStore struct {
+	mu     sync.Mutex
+	size   int
+	places []recentPlace // 古い順
+}
+
+type recentPlace struct {
+	suggestion AutocompleteSuggestion
+	keys       []string
+}
+
+var recentPlaces = &recentPlaceStore{}
+
+// RecordResolvedPlaces は /search で見つかった地点を入力補完の候補に加える
+func RecordResolvedPlaces(query string, results []SearchResponse) {
+	for _, r := range results {
+		coordinate, err := r.Coordinate()
+		if err != nil || r.Name == "" {
+			continue
+		}
+		keys := []string{jpnorm.Fold(r.Name)}
+		if folded := jpnorm.Fold(query); folded != "" {
+			keys = append(keys, folded)
+		}
+		recentPlaces.add(recentPlace{
+			suggestion: AutocompleteSuggestion{
+				ID:         r.ID,
+				Name:       r.Name,
+				Category:   r.Type,
+				Coordinate: []float64{coordinate[0], coordinate[1]},
+				Query:      r.Name,
+				Source:     "recent",
+			},
+			keys: keys,
+		})
+	}
+}
+
+func (s *recentPlaceStore) add(place recentPlace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size == 0 {
+		s.size = getEnvInt("AUTOCOMPLETE_RECENT_PLACES", 1000)
+	}
+	// 同じ地点は新しい方だけ残す
+	for i, p := range s.places {
+		if p.suggestion.ID == place.suggestion.ID {
+			s.places = append(s.places[:i], s.places[i+1:]...)
+			break
+		}
+	}
+	s.places = append(s.places, place)
+	if len(s.places) > s.size {
+		s.places = s.places[len(s.places)-s.size:]
+	}
+}
+
+// prefix は名前・検索語が folded で、または読みが reading で始まる地点を新しい順に返す
+func (s *recentPlaceStore) prefix(folded, reading string) []AutocompleteSuggestion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []AutocompleteSuggestion
+	for i := len(s.places) - 1; i >= 0; i-- {
+		for _, key := range s.places[i].keys {
+			if strings.HasPrefix(key, folded) || (reading != "" && jpnorm.IsKana(key) && strings.HasPrefix(jpnorm.LooseKana(key), reading)) {
+				result = append(result, s.places[i].suggestion)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -1,18 +1,21 @@
 package util
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+
+	"template-mobile-app-api/httpclient"
 )
 
 // BusStop represents a bus stop with its polygon data
 type BusStop struct {
 	ID        string      `json:"id"`
 	Name      string      `json:"name"`
+	Kana      string      `json:"kana,omitempty"`    // 読み (カタカナ)
+	NameEn    string      `json:"name_en,omitempty"` // 英語名 (ローマ字)
 	Latitude  float64     `json:"latitude"`
 	Longitude float64     `json:"longitude"`
 	Polygon   [][]float64 `json:"polygon"`
@@ -29,51 +32,70 @@ type RouteRequest struct {
 
 const (
 	OpenRouteServiceURL = "https://api.openrouteservice.org/v2/directions/cycling-road/geojson"
+
+	// Bus stops inside the start/end bounding box widened by this margin are avoided
+	// (at least busStopMinMarginMeters, or busStopMarginRatio of the trip length for longer trips),
+	// so routes that bend away from the straight line still avoid the stops they pass
+	busStopMinMarginMeters = 1000.0
+	busStopMarginRatio     = 0.3
 )
 
-// AvoidBusStops reads bus stops data and gets a route avoiding all bus stop polygons
-func AvoidBusStops(startCoord, endCoord Coordinate) (ORSGeometry, error) {
-	// Read bus stops data
-	busStopsData, err := os.ReadFile("data/bus_stops.json")
+// AvoidBusStops gets a route avoiding the bus stop polygons near the trip
+// baseOptions (e.g. from a rider profile) are sent along with the avoid polygons
+func AvoidBusStops(ctx context.Context, startCoord, endCoord Coordinate, baseOptions *ORSRouteOptions) (ORSFeature, error) {
+	// Only send the bus stops around the trip; the full list is larger than ORS accepts for avoid_polygons
+	index, err := BusStopIndex()
 	if err != nil {
-		return ORSGeometry{}, fmt.Errorf("failed to read bus stops file: %v", err)
-	}
-
-	var busStops []BusStop
-	if err := json.Unmarshal(busStopsData, &busStops); err != nil {
-		return ORSGeometry{}, fmt.Errorf("failed to parse bus stops JSON: %v", err)
+		return ORSFeature{}, err
 	}
+	west, south := min(startCoord[0], endCoord[0]), min(startCoord[1], endCoord[1])
+	east, north := max(startCoord[0], endCoord[0]), max(startCoord[1], endCoord[1])
+	margin := max(busStopMinMarginMeters, haversineMeters(startCoord[:], endCoord[:])*busStopMarginRatio)
+	lonDeg, latDeg := metersToDegrees(margin, (south+north)/2)
 
 	// Convert bus stop polygons to avoid polygons
 	var avoidPolygons [][][][]float64
-	for _, stop := range busStops {
-		if len(stop.Polygon) > 0 {
-			avoidPolygons = append(avoidPolygons, [][][]float64{stop.Polygon})
+	for _, m := range index.InBounds(west-lonDeg, south-latDeg, east+lonDeg, north+latDeg) {
+		if len(m.Value.Polygon) > 0 {
+			avoidPolygons = append(avoidPolygons, [][][]float64{m.Value.Polygon})
 		}
 	}
 
 	// Create request body
+	options := map[string]interface{}{}
+	if baseOptions != nil {
+		optionsJSON, err := json.Marshal(baseOptions)
+		if err != nil {
+			return ORSFeature{}, fmt.Errorf("failed to marshal route options: %v", err)
+		}
+		if err := json.Unmarshal(optionsJSON, &options); err != nil {
+			return ORSFeature{}, fmt.Errorf("failed to convert route options: %v", err)
+		}
+	}
+	// Keep avoid polygons already in the options (e.g. active restrictions)
+	if baseOptions != nil {
+		avoidPolygons = append(avoidPolygonCoordinates(baseOptions.AvoidPolygons), avoidPolygons...)
+	}
+	options["avoid_polygons"] = map[string]interface{}{
+		"type":        "MultiPolygon",
+		"coordinates": avoidPolygons,
+	}
 	requestBody := RouteRequest{
 		Coordinates: []Coordinate{startCoord, endCoord},
-		Options: map[string]interface{}{
-			"avoid_polygons": map[string]interface{}{
-				"type":        "MultiPolygon",
-				"coordinates": avoidPolygons,
-			},
-		},
+		Options:     options,
 	}
 
 	// Make API request
-	geometry, err := makeOpenRouteServiceRequest(requestBody)
+	feature, err := makeOpenRouteServiceRequest(ctx, requestBody)
 	if err != nil {
-		return ORSGeometry{}, err
+		return ORSFeature{}, err
 	}
 
-	return *geometry, nil
+	return *feature, nil
 }
 
 // GetRouteAvoidingSinglePolygon gets a route avoiding a single polygon
-func GetRouteAvoidingSinglePolygon(startCoord, endCoord Coordinate, avoidPolygon [][]float64) (*ORSGeometry, error) {
+func GetRouteAvoidingSinglePolygon(ctx context.Context, startCoord, endCoord Coordinate, avoidPolygon [][]float64) (*ORSGeometry, error) {
 	requestBody := RouteRequest{
 		Coordinates: []Coordinate{startCoord, endCoord},
 		Options: map[string]interface{}{
@@ -84,79 +106,64 @@ func GetRouteAvoidingSinglePolygon(startCoord, endCoord Coordinate, avoidPolygon
 		},
 	}
 
-	return makeOpenRouteServiceRequest(requestBody)
+	feature, err := makeOpenRouteServiceRequest(ctx, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	return &feature.Geometry, nil
 }
 
 // makeOpenRouteServiceRequest makes the actual HTTP request to OpenRouteService API
-func makeOpenRouteServiceRequest(requestBody RouteRequest) (*ORSGeometry, error) {
+// and returns the route feature (geometry with its segments and steps)
+func makeOpenRouteServiceRequest(ctx context.Context, requestBody RouteRequest) (*ORSFeature, error) {
 	// Marshal request body to JSON
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request body: %v", err)
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", OpenRouteServiceURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
-
-	// Set headers
-	req.Header.Set("Authorization", os.Getenv("OPEN_ROUTE_SERVICE_API_KEY"))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:142.0) Gecko/20100101 Firefox/142.0")
-
-	// Make HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %v", err)
-	}
-	defer resp.Body.Close()
+	// Identical in-flight requests share a single upstream call
+	body, err := ORSUpstream().Do(ctx, OpenRouteServiceURL+"\n"+string(jsonBody), func(ctx context.Context) ([]byte, error) {
+		// Set headers
+		header := http.Header{}
+		header.Set("Authorization", os.Getenv("OPEN_ROUTE_SERVICE_API_KEY"))
+		header.Set("Content-Type", "application/json")
+
+		// Route calculation has no side effects, so the POST is safe to retry
+		resp, err := httpclient.Default().Do(ctx, &httpclient.Request{
+			Method:      http.MethodPost,
+			URL:         OpenRouteServiceURL,
+			Header:      header,
+			Body:        jsonBody,
+			Idempotent:  true,
+			BeforeRetry: ORSUpstream().Wait,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to make request: %w", err)
+		}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+		// Check status code
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP error! status: %d, body: %s", resp.StatusCode, string(resp.Body))
+		}
+		return resp.Body, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return nil, err
 	}
 
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error! status: %d, body: %s", resp.StatusCode, string(body))
+	// Parse JSON response and extract features[0]
+	var response struct {
+		Features []ORSFeature `json:"features"`
 	}
-
-	// Parse JSON response and extract geometry
-	var response map[string]interface{}
 	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, fmt.Errorf("failed to parse response JSON: %v", err)
 	}
-
-	// Extract geometry from features[0].geometry
-	features, ok := response["features"].([]interface{})
-	if !ok || len(features) == 0 {
+	if len(response.Features) == 0 {
 		return nil, fmt.Errorf("no features found in response")
 	}
-
-	feature, ok := features[0].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid feature format")
-	}
-
-	geometryData, ok := feature["geometry"].(map[string]interface{})
-	if !ok {
+	if len(response.Features[0].Geometry.Coordinates) == 0 {
 		return nil, fmt.Errorf("no geometry found in feature")
 	}
-
-	// Convert to ORSGeometry
-	geometryJSON, err := json.Marshal(geometryData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal geometry: %v", err)
-	}
-
-	var geometry ORSGeometry
-	if err := json.Unmarshal(geometryJSON, &geometry); err != nil {
-		return nil, fmt.Errorf("failed to parse geometry: %v", err)
-	}
-
-	return &geometry, nil
+	return &response.Features[0], nil
 }
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache は有効期限付きのメモリキャッシュ
+// 上限を超えた場合は期限切れのものを、無ければ任意の1件を捨てる
+type ttlCache[T any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]ttlCacheEntry[T]
+}
+
+type ttlCacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+func newTTLCache[T any](ttl time.Duration, size int) *ttlCache[T] {
+	return &ttlCache[T]{ttl: ttl, size: size, entries: map[string]ttlCacheEntry[T]{}}
+}
+
+func (c *ttlCache[T]) Get(key string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[T]) Set(key string, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.size {
+		c.evict()
+	}
+	c.entries[key] = ttlCacheEntry[T]{value: value, expiresAt: time.Now().Add(c.ttl)}
+}
+
+func (c *ttlCache[T]) evict() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.size {
+			break
+		}
+		delete(c.entries, k)
+	}
+}
@@ -0,0 +1,450 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"template-mobile-app-api/provenance"
+)
+
+// Dataset はファイルから読み込むデータセット
+//
+// 読み込んだデータは atomic に入れ替えるので、処理中のリクエストは古いデータか新しいデータのどちらかを最後まで使う。
+// 読み込み・検証に失敗した場合は前のデータを使い続ける。
+type Dataset[T any] struct {
+	name        string
+	envKey      string // ファイルのパスを指定する環境変数
+	defaultPath string
+	parse       func(data []byte) ([]T, error) // 読み込みと検証
+
+	mu      sync.Mutex // 読み込みは1つずつ
+	seen    datasetStamp
+	lastErr error
+	errAt   time.Time
+	current atomic.Pointer[datasetVersion[T]]
+}
+
+// datasetVersion は読み込んだデータとその元になったファイル
+type datasetVersion[T any] struct {
+	items      []T
+	path       string
+	stamp      datasetStamp
+	sha256     string
+	provenance *provenance.Metadata // サイドカー (<ファイル>.meta.json) が無い場合は nil
+	loadedAt   time.Time            // ファイルが無く読み込んでいない場合はゼロ
+}
+
+// datasetStamp はファイルとサイドカーが変わったかを調べるための更新日時とサイズ
+type datasetStamp struct {
+	modTime     time.Time
+	size        int64 // ファイルが無い場合は -1
+	metaModTime time.Time
+	metaSize    int64
+}
+
+func (s datasetStamp) equal(other datasetStamp) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size &&
+		s.metaModTime.Equal(other.metaModTime) && s.metaSize == other.metaSize
+}
+
+func statDataset(path string) datasetStamp {
+	stamp := datasetStamp{size: -1, metaSize: -1}
+	if info, err := os.Stat(path); err == nil {
+		stamp.modTime, stamp.size = info.ModTime(), info.Size()
+	}
+	if info, err := os.Stat(provenance.SidecarPath(path)); err == nil {
+		stamp.metaModTime, stamp.metaSize = info.ModTime(), info.Size()
+	}
+	return stamp
+}
+
+// readProvenance はサイドカーを読み込む。無い・読めない場合は nil
+func readProvenance(path string) *provenance.Metadata {
+	meta, err := provenance.Read(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("dataset provenance error:", err)
+		}
+		return nil
+	}
+	return &meta
+}
+
+// managedDataset は型によらずデータセットを扱うためのインターフェース
+type managedDataset interface {
+	Name() string
+	Reload() error
+	reloadIfChanged() (bool, error)
+	Status() DatasetStatus
+}
+
+var (
+	datasets []managedDataset
+
+	// データセットを入れ替えるたびに増やす (索引などの作り直しに使う)
+	datasetGeneration atomic.Uint64
+)
+
+// newDataset はデータセットを登録する
+// パスは .env を読んだ後に決まるので、読み込むときに環境変数を見る
+func newDataset[T any](name, envKey, defaultPath string, parse func([]byte) ([]T, error)) *Dataset[T] {
+	d := &Dataset[T]{name: name, envKey: envKey, defaultPath: defaultPath, parse: parse}
+	datasets = append(datasets, d)
+	return d
+}
+
+// Name はデータセットの名前を返す
+func (d *Dataset[T]) Name() string { return d.name }
+
+// Path はデータセットのファイルのパスを返す
+func (d *Dataset[T]) Path() string {
+	if path := os.Getenv(d.envKey); path != "" {
+		return path
+	}
+	return d.defaultPath
+}
+
+// Get は読み込み済みのデータを返す
+// LoadDatasets より前に使われた場合はその場で読み込む
+func (d *Dataset[T]) Get() []T {
+	if v := d.current.Load(); v != nil {
+		return v.items
+	}
+	d.Reload()
+	return d.current.Load().items
+}
+
+// Reload はファイルを読み込み、検証できた場合だけデータを入れ替える
+// ファイルが無い場合は最初の読み込みなら空として扱い、読み込み済みなら前のデータを使い続ける
+func (d *Dataset[T]) Reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := d.Path()
+	stamp := statDataset(path)
+	d.seen = stamp
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && d.current.Load() == nil {
+		d.current.Store(&datasetVersion[T]{path: path, stamp: stamp})
+		return nil
+	}
+	var items []T
+	if err == nil {
+		items, err = d.parse(data)
+	}
+	if err != nil {
+		d.lastErr, d.errAt = fmt.Errorf("%s: %w", d.name, err), time.Now()
+		if d.current.Load() == nil {
+			d.current.Store(&datasetVersion[T]{path: path, stamp: stamp})
+		}
+		return d.lastErr
+	}
+
+	d.current.Store(&datasetVersion[T]{
+		items:      items,
+		path:       path,
+		stamp:      stamp,
+		sha256:     provenance.ChecksumBytes(data),
+		provenance: readProvenance(path),
+		loadedAt:   time.Now(),
+	})
+	d.lastErr = nil
+	datasetGeneration.Add(1)
+	return nil
+}
+
+// reloadIfChanged はファイルの更新日時かサイズが前回の読み込みから変わっていれば読み込み直す
+// 書き込み途中で検証に失敗しても、書き終わればもう一度変わるので読み込み直される
+func (d *Dataset[T]) reloadIfChanged() (bool, error) {
+	stamp := statDataset(d.Path())
+	d.mu.Lock()
+	seen := d.seen
+	d.mu.Unlock()
+	if stamp.equal(seen) {
+		return false, nil
+	}
+	return true, d.Reload()
+}
+
+// replace は書き込んだファイルの内容を読み込み直さずにそのまま使う (ファイルを書くストアから呼ぶ)
+func (d *Dataset[T]) replace(items []T) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := d.Path()
+	d.seen = statDataset(path)
+	sum, _ := provenance.Checksum(path)
+	d.current.Store(&datasetVersion[T]{items: items, path: path, stamp: d.seen, sha256: sum, provenance: readProvenance(path), loadedAt: time.Now()})
+	d.lastErr = nil
+	datasetGeneration.Add(1)
+}
+
+// loadError はデータを一度も読み込めていない場合にその理由を返す
+func (d *Dataset[T]) loadError() error {
+	d.Get()
+	if v := d.current.Load(); !v.loadedAt.IsZero() {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastErr != nil {
+		return d.lastErr
+	}
+	return fmt.Errorf("%s: %s not found", d.name, d.Path())
+}
+
+// DatasetStatus はデータセットの読み込み状況
+type DatasetStatus struct {
+	Name       string     `json:"name" example:"violation_rates"`
+	Path       string     `json:"path,omitempty" example:"data/violation_rates.json"`
+	Loaded     bool       `json:"loaded"` // ファイルを読み込めている (false の場合は空として扱う)
+	Records    int        `json:"records" example:"84"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"` // 読み込んだファイルの更新日時
+	LoadedAt   *time.Time `json:"loaded_at,omitempty"`
+	Error      string     `json:"error,omitempty"` // 最後の読み込みの失敗 (前のデータを使い続けている)
+	ErrorAt    *time.Time `json:"error_at,omitempty"`
+	SHA256     string     `json:"sha256,omitempty"` // 読み込んだファイルのチェックサム
+
+	// サイドカー (<ファイル>.meta.json) の出典。サイドカーのチェックサムが読み込んだファイルと違う場合は provenance_stale
+	Provenance      *provenance.Metadata `json:"provenance,omitempty"`
+	ProvenanceStale bool                 `json:"provenance_stale,omitempty"`
+}
+
+// Status はデータセットの読み込み状況を返す
+func (d *Dataset[T]) Status() DatasetStatus {
+	d.Get()
+	v := d.current.Load()
+	status := DatasetStatus{Name: d.name, Path: v.path, Loaded: !v.loadedAt.IsZero(), Records: len(v.items)}
+	if status.Loaded {
+		modifiedAt, loadedAt := v.stamp.modTime, v.loadedAt
+		status.ModifiedAt, status.LoadedAt = &modifiedAt, &loadedAt
+		status.SHA256 = v.sha256
+		status.Provenance = v.provenance
+		status.ProvenanceStale = v.provenance != nil && v.provenance.SHA256 != v.sha256
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastErr != nil {
+		errAt := d.errAt
+		status.Error, status.ErrorAt = d.lastErr.Error(), &errAt
+	}
+	return status
+}
+
+// parseJSONDataset は JSON の配列を読み込み、1件ずつ validate で確かめる
+func parseJSONDataset[T any](validate func(T) error) func([]byte) ([]T, error) {
+	return func(data []byte) ([]T, error) {
+		var items []T
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+		for i, item := range items {
+			if err := validate(item); err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		return items, nil
+	}
+}
+
+// validateCoordinate は [経度, 緯度] が範囲内かを確かめる
+func validateCoordinate(coordinate []float64) error {
+	if len(coordinate) != 2 {
+		return fmt.Errorf("coordinate must be [lon, lat], got %v", coordinate)
+	}
+	lon, lat := coordinate[0], coordinate[1]
+	if math.IsNaN(lon) || math.IsNaN(lat) || lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		return fmt.Errorf("coordinate out of range: %v", coordinate)
+	}
+	return nil
+}
+
+// derived はデータセットから作る索引などのキャッシュ
+// どれかのデータセットが入れ替わったら次に使うときに作り直す
+type derived[T any] struct {
+	build func() T
+	mu    sync.Mutex
+	entry atomic.Pointer[derivedEntry[T]]
+}
+
+type derivedEntry[T any] struct {
+	generation uint64
+	value      T
+}
+
+func newDerived[T any](build func() T) *derived[T] {
+	return &derived[T]{build: build}
+}
+
+func (d *derived[T]) get() T {
+	generation := datasetGeneration.Load()
+	if e := d.entry.Load(); e != nil && e.generation == generation {
+		return e.value
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e := d.entry.Load(); e != nil && e.generation == generation {
+		return e.value
+	}
+	// 作っている間に入れ替わった場合は古い generation で保存されるので、次に使うときにまた作り直す
+	value := d.build()
+	d.entry.Store(&derivedEntry[T]{generation: generation, value: value})
+	return value
+}
+
+// LoadDatasets は起動時に全てのデータセットを読み込む
+func LoadDatasets() {
+	for _, d := range datasets {
+		if err := d.Reload(); err != nil {
+			fmt.Println("dataset load error:", err)
+			continue
+		}
+		if status := d.Status(); status.Loaded {
+			fmt.Printf("dataset %s: %d records from %s\n", status.Name, status.Records, status.Path)
+		}
+	}
+}
+
+// WatchDatasets はデータセットのファイルを DATASET_POLL_INTERVAL (デフォルト10秒, 0 で無効) ごとに調べ、変わっていれば読み込み直す
+func WatchDatasets(ctx context.Context) {
+	interval := getEnvDuration("DATASET_POLL_INTERVAL", 10*time.Second)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded := false
+		for _, d := range datasets {
+			changed, err := d.reloadIfChanged()
+			switch {
+			case err != nil:
+				fmt.Println("dataset reload error (keeping previous version):", err)
+			case changed:
+				reloaded = true
+				fmt.Printf("dataset %s reloaded: %d records\n", d.Name(), d.Status().Records)
+			}
+		}
+		if reloaded {
+			go NameHazards(ctx)
+		}
+	}
+}
+
+// ReloadDatasets は name のデータセット (空なら全て) を読み込み直し、読み込み状況を返す
+func ReloadDatasets(name string) ([]DatasetStatus, error) {
+	var statuses []DatasetStatus
+	var errs []error
+	for _, d := range datasets {
+		if name != "" && d.Name() != name {
+			continue
+		}
+		if err := d.Reload(); err != nil {
+			errs = append(errs, err)
+		}
+		statuses = append(statuses, d.Status())
+	}
+	if statuses == nil {
+		return nil, errDatasetNotFound
+	}
+	return statuses, errors.Join(errs...)
+}
+
+var errDatasetNotFound = errors.New("dataset not found")
+
+// 経路検索・ジオコーディングで使うサービスの出典 (データセットの出典に加えてクレジットに表示する)
+var serviceAttributions = []provenance.Source{
+	provenance.OpenRouteService,
+	provenance.OpenStreetMap,
+	provenance.GSI,
+}
+
+// DatasetsResponse は /datasets のレスポンス
+type DatasetsResponse struct {
+	Datasets     []DatasetStatus     `json:"datasets"`
+	Attributions []provenance.Source `json:"attributions"` // クレジット画面に表示する出典 (同じ出典は1件にまとめる)
+}
+
+// Datasets は全てのデータセットの読み込み状況と、クレジットに表示する出典を返す
+func Datasets() DatasetsResponse {
+	response := DatasetsResponse{Datasets: []DatasetStatus{}}
+	seen := map[string]bool{}
+	addAttribution := func(s provenance.Source) {
+		if s.Attribution == "" || seen[s.Name] {
+			return
+		}
+		seen[s.Name] = true
+		response.Attributions = append(response.Attributions, s)
+	}
+	for _, d := range datasets {
+		status := d.Status()
+		response.Datasets = append(response.Datasets, status)
+		if status.Provenance != nil {
+			for _, s := range status.Provenance.Sources {
+				addAttribution(s)
+			}
+		}
+	}
+	for _, s := range serviceAttributions {
+		addAttribution(s)
+	}
+	return response
+}
+
+// GetDatasets godoc
+// @Summary データセットの出典
+// @Description 読み込んでいるデータセットの件数・出典 (取得元・ライセンス・取得日時・作成したツール・チェックサム) と、クレジット画面に表示する OSM・ORS・ODPT・東京都オープンデータなどの出典を返す。ファイルのパスと読み込みエラーは管理者にだけ返す
+// @Tags datasets
+// @Produce json
+// @Success 200 {object} DatasetsResponse
+// @Router /datasets [get]
+func GetDatasets(c *gin.Context) {
+	response := Datasets()
+	if !isAdmin(c) {
+		for i := range response.Datasets {
+			response.Datasets[i].Path = ""
+			response.Datasets[i].Error, response.Datasets[i].ErrorAt = "", nil
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// PostDatasetsReload godoc
+// @Summary データセットの読み込み直し
+// @Description データセットのファイルを読み込み直す (管理者のみ)。検証に失敗したデータセットは前のデータを使い続け、422 と読み込み状況を返す
+// @Tags datasets
+// @Produce json
+// @Param name query string false "データセットの名前 (省略時は全て)"
+// @Success 200 {object} []DatasetStatus "読み込み状況"
+// @Failure 401 {object} ErrorResponse "管理者でない"
+// @Failure 404 {object} ErrorResponse "データセットが無い"
+// @Failure 422 {object} []DatasetStatus "検証に失敗したデータセットがある"
+// @Router /datasets/reload [post]
+func PostDatasetsReload(c *gin.Context) {
+	statuses, err := ReloadDatasets(c.Query("name"))
+	if errors.Is(err, errDatasetNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Not found", Message: "unknown dataset: " + c.Query("name")})
+		return
+	}
+	go NameHazards(context.Background())
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, statuses)
+		return
+	}
+	c.JSON(http.StatusOK, statuses)
+}
@@ -1,32 +1,37 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	_ "template-mobile-app-api/docs"
+	"template-mobile-app-api/httpclient"
 )
 
 // =================レスポンス=================
 type DirectionsResponse struct {
 	// https://openrouteservice.org/dev/#/api-docs/v2/directions/{profile}/geojson/get
 	// のレスポンスを構造体に
-	Type          string         `json:"type"`
-	BBox          []float64      `json:"bbox"`
-	Features      []ORSFeature   `json:"features"`
-	Metadata      ORSMetadata    `json:"metadata"`
-	WarningPoints []WarningPoint `json:"warning_points"` //XXX 追加項目
-	ComfortScore  int            `json:"comfort_score"`  //XXX 追加項目, 0-100のスコア
-	SessoinID     string         `json:"session_id"`     //XXX 追加項目, セッションID
+	Type                string              `json:"type"`
+	BBox                []float64           `json:"bbox"`
+	Features            []ORSFeature        `json:"features"`
+	Metadata            ORSMetadata         `json:"metadata"`
+	WarningPoints       []RouteWarningPoint `json:"warning_points"`                 //XXX 追加項目, 経路沿いの取締強化交差点・事故多発地点・承認済みの報告 (通る順)
+	ComfortScore        int                 `json:"comfort_score"`                  //XXX 追加項目, 0-100のスコア
+	SessoinID           string              `json:"session_id"`                     //XXX 追加項目, セッションID
+	Profile             string              `json:"profile,omitempty"`              //XXX 追加項目, 指定されたライダープロファイル
+	EstimatedDuration   float64             `json:"estimated_duration,omitempty"`   //XXX 追加項目, プロファイルの想定速度での所要時間(秒)
+	AvoidedRestrictions []string            `json:"avoided_restrictions,omitempty"` //XXX 追加項目, 出発日時に有効で回避した通行規制の id
 }
 
 // ORSFeature represents a feature in the GeoJSON response
@@ -152,6 +157,7 @@ type ORSRestrictions struct {
 	MaximumSlopedKerb *float32 `json:"maximum_sloped_kerb,omitempty"`
 	MaximumIncline    *int     `json:"maximum_incline,omitempty"`
 	MinimumWidth      *float32 `json:"minimum_width,omitempty"`
+	Gradient          *int     `json:"gradient,omitempty"`
 }
 
 // ORSRoundTripOptions represents options for round trip routing
@@ -203,10 +209,15 @@ type ORSErrorResponse struct {
 // @Param via_bike_parking query boolean false "自転車駐輪場経由ルート" default(true)
 // @Param avoid_bus_stops query boolean false "バス停回避" default(true)
 // @Param avoid_traffic_lights query boolean false "信号回避" default(true)
+// @Param profile query string false "ライダープロファイル (/profiles 参照)。回避フラグを省略した場合はプロファイルの既定値を使う" example(beginner)
+// @Param depart_at query string false "出発日時 (RFC3339 または 2006-01-02T15:04 の日本時間、デフォルトは現在)。この時点で有効な通行規制を避ける" example(2025-06-01T14:00:00+09:00)
+// @Param Accept-Language header string false "注意点のメッセージの言語 (ja, en。デフォルト ja)"
 // @Success 200 {object} DirectionsResponse "GeoJson形式のルート情報"
 // @Failure 400 {object} ORSErrorResponse "リクエストパラメータ不正"
 // @Failure 404 {object} ORSErrorResponse "ルートが見つからない"
+// @Failure 429 {object} ORSErrorResponse "OpenRouteServiceのリクエスト予算超過"
 // @Failure 500 {object} ORSErrorResponse "サーバー内部エラー"
+// @Failure 503 {object} ORSErrorResponse "OpenRouteServiceの順番待ちがタイムアウト"
 // @Router /directions/bicycle [get]
 func GetDirections(c *gin.Context) {
 	// クエリパラメータの取得
@@ -216,47 +227,98 @@ func GetDirections(c *gin.Context) {
 	avoidBusStops := c.DefaultQuery("avoid_bus_stops", "false")
 	avoidTrafficLights := c.DefaultQuery("avoid_traffic_lights", "false")
 
-	status, orsResp := GetDirectionsBase(start, end)
+	// プロファイル指定時は、明示されていないフラグをプロファイルの既定値にする
+	var profile *RiderProfile
+	if name := c.Query("profile"); name != "" {
+		p, found := LookupRiderProfile(name)
+		if !found {
+			var er ORSErrorResponse
+			er.Error.Code = http.StatusBadRequest
+			er.Error.Message = fmt.Sprintf("unknown profile: %s", name)
+			c.JSON(http.StatusBadRequest, er)
+			return
+		}
+		profile = &p
+		viaBikeParking = c.DefaultQuery("via_bike_parking", strconv.FormatBool(p.ViaBikeParking))
+		avoidBusStops = c.DefaultQuery("avoid_bus_stops", strconv.FormatBool(p.AvoidBusStops))
+		avoidTrafficLights = c.DefaultQuery("avoid_traffic_lights", strconv.FormatBool(p.AvoidTrafficLights))
+	}
+	var routeOptions *ORSRouteOptions
+	if profile != nil {
+		routeOptions = profile.RouteOptions()
+	}
+	departAt, err := ParseDepartAt(c.Query("depart_at"))
+	if err != nil {
+		var er ORSErrorResponse
+		er.Error.Code = http.StatusBadRequest
+		er.Error.Message = err.Error()
+		c.JSON(http.StatusBadRequest, er)
+		return
+	}
+	// 出発日時に有効な通行規制を避ける (座標が不正な場合は GetDirectionsBaseWithOptions がエラーにする)
+	var avoidedRestrictions []string
+	startCoord, errStart := ParseCoordinate(start)
+	endCoord, errEnd := ParseCoordinate(end)
+	if errStart == nil && errEnd == nil {
+		routeOptions, avoidedRestrictions = withActiveRestrictions(routeOptions, []Coordinate{startCoord, endCoord}, departAt)
+	}
+
+	ctx := c.Request.Context()
+	status, orsResp := GetDirectionsBaseWithOptions(ctx, start, end, routeOptions)
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", RetryAfterSeconds(ORSUpstream()))
+	}
 	directionsResponse, ok := orsResp.(DirectionsResponse)
 	if ok {
 		//directionsResponse.Features[0].Geometry.Coordinates = GetBicycleParkingDirection(directionsResponse.Features[0].Geometry.Coordinates, [][]float64{})
 
-		//TODO ComfortScoreはサンプル
-		directionsResponse.ComfortScore = 49 // https://github.com/rowicy/charimachi/issues/34
-		var comfortLevel = 0
-		if viaBikeParking == "true" {
-			comfortLevel++
-		}
-		if avoidBusStops == "true" {
-			comfortLevel++
-		}
-		if avoidTrafficLights == "true" {
-			comfortLevel++
-		}
-		switch comfortLevel {
-		case 1:
-			directionsResponse.ComfortScore = 79
-		case 2:
-			directionsResponse.ComfortScore = 89
-		case 3:
-			directionsResponse.ComfortScore = 100
+		if profile != nil {
+			directionsResponse.Profile = profile.Name
+			directionsResponse.ComfortScore = profile.ComfortScore(viaBikeParking == "true", avoidBusStops == "true", avoidTrafficLights == "true")
+			directionsResponse.EstimatedDuration = profile.EstimatedDuration(directionsResponse.Features[0].Properties.Summary.Distance)
+		} else {
+			//TODO ComfortScoreはサンプル
+			directionsResponse.ComfortScore = 49 // https://github.com/rowicy/charimachi/issues/34
+			var comfortLevel = 0
+			if viaBikeParking == "true" {
+				comfortLevel++
+			}
+			if avoidBusStops == "true" {
+				comfortLevel++
+			}
+			if avoidTrafficLights == "true" {
+				comfortLevel++
+			}
+			switch comfortLevel {
+			case 1:
+				directionsResponse.ComfortScore = 79
+			case 2:
+				directionsResponse.ComfortScore = 89
+			case 3:
+				directionsResponse.ComfortScore = 100
+			}
 		}
-		directionsResponse.SessoinID = GenerateSessionID()
-		SessionIDResponse[directionsResponse.SessoinID] = directionsResponse.Features[0].Geometry
 	}
 	if avoidBusStops == "true" && ok {
-		var geometry, err = AvoidBusStops(Coordinate{directionsResponse.Metadata.Query.Coordinates[0][0], directionsResponse.Metadata.Query.Coordinates[0][1]}, Coordinate{directionsResponse.Metadata.Query.Coordinates[1][0], directionsResponse.Metadata.Query.Coordinates[1][1]})
+		var feature, err = AvoidBusStops(ctx, Coordinate{directionsResponse.Metadata.Query.Coordinates[0][0], directionsResponse.Metadata.Query.Coordinates[0][1]}, Coordinate{directionsResponse.Metadata.Query.Coordinates[1][0], directionsResponse.Metadata.Query.Coordinates[1][1]}, routeOptions)
 		if err == nil {
 			fmt.Println("AvoidBusStops success")
-			directionsResponse.Features[0].Geometry = geometry
+			directionsResponse.Features[0] = feature
 		} else {
 			fmt.Println("AvoidBusStops error:", err)
 		}
 	}
+	if ok {
+		// 注意点とセッションは最終的な経路に対して付ける
+		directionsResponse.AvoidedRestrictions = avoidedRestrictions
+		directionsResponse.WarningPoints = RouteWarningPoints(directionsResponse.Features[0], MessageLanguage(c))
+		directionsResponse.SessoinID = GenerateSessionID()
+		SaveSessionGeometry(directionsResponse.SessoinID, directionsResponse.Features[0].Geometry)
+	}
 	c.JSON(status, directionsResponse)
 }
 
-func GetBicycleParkingDirection(searchCoordinates [][]float64, coordinates [][]float64) (returnCoordinates [][]float64) {
+func GetBicycleParkingDirection(ctx context.Context, searchCoordinates [][]float64, coordinates [][]float64) (returnCoordinates [][]float64) {
 	var distance = 0.0
 	var prePosition = searchCoordinates[0]
 	for _, v := range searchCoordinates {
@@ -270,28 +332,24 @@ func GetBicycleParkingDirection(searchCoordinates [][]float64, coordinates [][]f
 		fmt.Println("distance:", distance)
 
 		var query = "駐輪場"
-		var query2 = "&viewbox="
-		query2 += strconv.FormatFloat(v[0]-0.011, 'f', -1, 64)
-		query2 += ","
-		query2 += strconv.FormatFloat(v[1]-0.011, 'f', -1, 64)
-		query2 += ","
-		query2 += strconv.FormatFloat(v[0]+0.011, 'f', -1, 64)
-		query2 += ","
-		query2 += strconv.FormatFloat(v[1]+0.011, 'f', -1, 64)
-		query2 += "&bounded=1"
-		searchResp := GetSearchBase(query, query2)
-		searchResponse, ok := searchResp.([]SearchResponse)
+		options := SearchOptions{
+			Focus:   &Coordinate{v[0], v[1]},
+			Viewbox: &[4]float64{v[0] - 0.011, v[1] - 0.011, v[0] + 0.011, v[1] + 0.011},
+			Bounded: true,
+		}
+		searchResponse, err := GetSearchBase(ctx, query, options)
+		ok := err == nil
 
 		fmt.Println(searchResponse)
 
 		if ok && (distance > 500) && len(searchResponse) != 0 {
-			_, orsResp := GetDirectionsBase(
+			_, orsResp := GetDirectionsBase(ctx,
 				searchResponse[0].Lon+","+searchResponse[0].Lat,
 				strconv.FormatFloat(searchCoordinates[len(searchCoordinates)-1][0], 'f', -1, 64)+","+strconv.FormatFloat(searchCoordinates[len(searchCoordinates)-1][1], 'f', -1, 64))
 			directionsResponse, directionOK := orsResp.(DirectionsResponse)
 			if directionOK {
 				coordinates = append(coordinates, v)
-				coordinates = GetBicycleParkingDirection(directionsResponse.Features[0].Geometry.Coordinates, coordinates)
+				coordinates = GetBicycleParkingDirection(ctx, directionsResponse.Features[0].Geometry.Coordinates, coordinates)
 				return coordinates
 			}
 		} else {
@@ -303,8 +361,19 @@ func GetBicycleParkingDirection(searchCoordinates [][]float64, coordinates [][]f
 }
 
 func GetDirectionsBase(
+	ctx context.Context,
 	start string,
 	end string) (status int, res any) {
+	return GetDirectionsBaseWithOptions(ctx, start, end, nil)
+}
+
+// GetDirectionsBaseWithOptions はORSのルートオプションを指定してルートを取得する
+// options が nil の場合は従来通り GET で、指定がある場合は POST で問い合わせる
+func GetDirectionsBaseWithOptions(
+	ctx context.Context,
+	start string,
+	end string,
+	options *ORSRouteOptions) (status int, res any) {
 
 	// バリデーション
 	if start == "" || end == "" {
@@ -314,6 +383,61 @@ func GetDirectionsBase(
 		return http.StatusBadRequest, er
 	}
 
+	if options == nil {
+		return requestDirections(ctx, nil, start, end, nil)
+	}
+
+	startCoord, err := ParseCoordinate(start)
+	if err != nil {
+		var er ORSErrorResponse
+		er.Error.Code = http.StatusBadRequest
+		er.Error.Message = fmt.Sprintf("invalid start: %v", err)
+		return http.StatusBadRequest, er
+	}
+	endCoord, err := ParseCoordinate(end)
+	if err != nil {
+		var er ORSErrorResponse
+		er.Error.Code = http.StatusBadRequest
+		er.Error.Message = fmt.Sprintf("invalid end: %v", err)
+		return http.StatusBadRequest, er
+	}
+	return requestDirections(ctx, []Coordinate{startCoord, endCoord}, "", "", options)
+}
+
+// ParseCoordinate は "経度,緯度" 形式の文字列を Coordinate に変換する
+func ParseCoordinate(value string) (Coordinate, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return Coordinate{}, fmt.Errorf("coordinate must be \"lon,lat\": %q", value)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return Coordinate{}, fmt.Errorf("invalid longitude: %q", parts[0])
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return Coordinate{}, fmt.Errorf("invalid latitude: %q", parts[1])
+	}
+	if lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		return Coordinate{}, fmt.Errorf("coordinate out of range: %q", value)
+	}
+	return Coordinate{lon, lat}, nil
+}
+
+// FormatCoordinate は Coordinate を "経度,緯度" 形式の文字列にする
+func FormatCoordinate(c Coordinate) string {
+	return strconv.FormatFloat(c[0], 'f', -1, 64) + "," + strconv.FormatFloat(c[1], 'f', -1, 64)
+}
+
+// requestDirections はORSへルートを問い合わせる
+// coordinates が nil の場合は start / end を使って GET、それ以外は coordinates と options を POST する
+func requestDirections(
+	ctx context.Context,
+	coordinates []Coordinate,
+	start string,
+	end string,
+	options *ORSRouteOptions) (status int, res any) {
+
 	// APIキー取得
 	apiKey := os.Getenv("OPEN_ROUTE_SERVICE_API_KEY")
 	if apiKey == "" {
@@ -323,48 +447,83 @@ func GetDirectionsBase(
 		return http.StatusInternalServerError, er
 	}
 
-	// ORSへリクエスト
-	base := "https://api.openrouteservice.org/v2/directions/cycling-road"
-	u, err := url.Parse(base)
-	if err != nil {
-		var er ORSErrorResponse
-		er.Error.Code = http.StatusInternalServerError
-		er.Error.Message = fmt.Sprintf("invalid base url: %v", err)
-		return http.StatusInternalServerError, er
-	}
+	var request *httpclient.Request
+	if coordinates == nil {
+		// ORSへリクエスト
+		base := "https://api.openrouteservice.org/v2/directions/cycling-road"
+		u, err := url.Parse(base)
+		if err != nil {
+			var er ORSErrorResponse
+			er.Error.Code = http.StatusInternalServerError
+			er.Error.Message = fmt.Sprintf("invalid base url: %v", err)
+			return http.StatusInternalServerError, er
+		}
 
-	q := u.Query()
-	q.Set("api_key", apiKey)
-	q.Set("start", start)
-	q.Set("end", end)
-	u.RawQuery = q.Encode()
+		q := u.Query()
+		q.Set("api_key", apiKey)
+		q.Set("start", start)
+		q.Set("end", end)
+		u.RawQuery = q.Encode()
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(u.String())
-	if err != nil {
-		var er ORSErrorResponse
-		er.Error.Code = http.StatusBadGateway
-		er.Error.Message = err.Error()
-		return http.StatusBadGateway, er
-	}
-	defer resp.Body.Close()
+		request = &httpclient.Request{
+			Method:      http.MethodGet,
+			URL:         u.String(),
+			BeforeRetry: ORSUpstream().Wait,
+		}
+	} else {
+		body, err := json.Marshal(struct {
+			Coordinates []Coordinate     `json:"coordinates"`
+			Options     *ORSRouteOptions `json:"options,omitempty"`
+		}{coordinates, options})
+		if err != nil {
+			var er ORSErrorResponse
+			er.Error.Code = http.StatusInternalServerError
+			er.Error.Message = fmt.Sprintf("failed to marshal request body: %v", err)
+			return http.StatusInternalServerError, er
+		}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		var er ORSErrorResponse
-		er.Error.Code = http.StatusBadGateway
-		er.Error.Message = fmt.Sprintf("failed to read upstream response: %v", err)
-		return http.StatusBadGateway, er
+		header := http.Header{}
+		header.Set("Authorization", apiKey)
+		header.Set("Content-Type", "application/json")
+		// ルート計算は副作用が無いのでPOSTでもリトライしてよい
+		request = &httpclient.Request{
+			Method:      http.MethodPost,
+			URL:         OpenRouteServiceURL,
+			Header:      header,
+			Body:        body,
+			Idempotent:  true,
+			BeforeRetry: ORSUpstream().Wait,
+		}
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var upstreamErr ORSErrorResponse
-		_ = json.Unmarshal(body, &upstreamErr)
-		if upstreamErr.Error.Message == "" {
-			upstreamErr.Error.Code = resp.StatusCode
-			upstreamErr.Error.Message = fmt.Sprintf("upstream returned status %d", resp.StatusCode)
+	// 同じ経路のリクエストが同時に来た場合は1回のリクエストにまとめる
+	body, err := ORSUpstream().Do(ctx, request.URL+"\n"+string(request.Body), func(ctx context.Context) ([]byte, error) {
+		resp, err := httpclient.Default().Do(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Body: resp.Body}
+		}
+		return resp.Body, nil
+	})
+	if err != nil {
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) {
+			var upstreamErr ORSErrorResponse
+			_ = json.Unmarshal(statusErr.Body, &upstreamErr)
+			if upstreamErr.Error.Message == "" {
+				upstreamErr.Error.Code = statusErr.StatusCode
+				upstreamErr.Error.Message = fmt.Sprintf("upstream returned status %d", statusErr.StatusCode)
+			}
+			return http.StatusBadGateway, upstreamErr
 		}
-		return http.StatusBadGateway, upstreamErr
+
+		var er ORSErrorResponse
+		status := UpstreamStatus(err)
+		er.Error.Code = status
+		er.Error.Message = err.Error()
+		return status, er
 	}
 
 	//TODO OpenRouteServiceのレスポンスをそのままレスポンスにパースしているのでorsRespを加工する処理をかく
@@ -376,24 +535,5 @@ func GetDirectionsBase(
 		return http.StatusInternalServerError, er
 	}
 
-	//TODO WarningPointsはサンプル
-	// if orsResp.WarningPoints == nil {
-	// 	coodinates := orsResp.Features[0].Geometry.Coordinates
-	// 	orsResp.WarningPoints = []WarningPoint{
-	// 		{
-	// 			Type:       "intersection",
-	// 			Name:       "地獄谷",
-	// 			Coordinate: coodinates[1],
-	// 			Message:    "過去に事故が多発した地点です。注意してください。",
-	// 		},
-	// 		{
-	// 			Type:       "straight_road",
-	// 			Name:       "無限道路",
-	// 			Coordinate: coodinates[len(coodinates)-2],
-	// 			Message:    "この道路は直線で、速度を出しやすいです。安全運転を心掛けてください。",
-	// 		},
-	// 	}
-	// }
-
 	return http.StatusOK, orsResp
 }
@@ -0,0 +1,265 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxErrandStops = 25
+	// この数以下の立ち寄り先は全探索(動的計画法)で最適な順番を求める
+	maxExactErrandStops = 12
+)
+
+// ErrandsRequest は /errands/bicycle のリクエスト
+type ErrandsRequest struct {
+	Start   []float64   `json:"start" binding:"required"` // 出発地 [経度, 緯度]
+	End     []float64   `json:"end,omitempty"`            // 到着地 [経度, 緯度]。省略時は最後の立ち寄り先で終了
+	Stops   [][]float64 `json:"stops" binding:"required"` // 立ち寄り先 [[経度, 緯度], ...]
+	Profile string      `json:"profile,omitempty" example:"commuter"`
+	// 出発日時 (RFC3339 または 2006-01-02T15:04 の日本時間、省略時は現在)。この時点で有効な通行規制を避ける
+	DepartAt string `json:"depart_at,omitempty" example:"2025-06-01T14:00:00+09:00"`
+}
+
+// ErrandsResponse は立ち寄り順と全行程のルート
+type ErrandsResponse struct {
+	Order          []int              `json:"order"`           // stops のインデックスを訪問順に並べたもの
+	Duration       float64            `json:"duration"`        // 行列から求めた所要時間の合計(秒)
+	HazardExposure int                `json:"hazard_exposure"` // 行列から求めた危険箇所の数の合計
+	Route          DirectionsResponse `json:"route"`           // 全行程のルート
+	SessionID      string             `json:"session_id"`
+}
+
+// GetErrandsRoute godoc
+// @Summary 複数の立ち寄り先の巡回順とルート
+// @Description 出発地・到着地(任意)と最大25件の立ち寄り先から、所要時間と危険箇所のペナルティの合計が最小になる順番を求め、全行程のルートを返す
+// @Tags map
+// @Accept json
+// @Produce json
+// @Param request body ErrandsRequest true "出発地・到着地・立ち寄り先"
+// @Param Accept-Language header string false "注意点のメッセージの言語 (ja, en。デフォルト ja)"
+// @Success 200 {object} ErrandsResponse "立ち寄り順と全行程のルート"
+// @Failure 400 {object} ErrorResponse "リクエストパラメータ不正"
+// @Failure 429 {object} ORSErrorResponse "OpenRouteServiceのリクエスト予算超過"
+// @Failure 502 {object} ORSErrorResponse "ルート取得失敗"
+// @Router /errands/bicycle [post]
+func GetErrandsRoute(c *gin.Context) {
+	var request ErrandsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	start, err := toCoordinates([][]float64{request.Start}, 1)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid start", Message: err.Error()})
+		return
+	}
+	stops, err := toCoordinates(request.Stops, maxErrandStops)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid stops", Message: err.Error()})
+		return
+	}
+	var end []Coordinate
+	if request.End != nil {
+		end, err = toCoordinates([][]float64{request.End}, 1)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid end", Message: err.Error()})
+			return
+		}
+	}
+
+	speedKmh := matrixDefaultSpeedKmh
+	var routeOptions *ORSRouteOptions
+	if request.Profile != "" {
+		profile, ok := LookupRiderProfile(request.Profile)
+		if !ok {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid profile", Message: fmt.Sprintf("unknown profile: %s", request.Profile)})
+			return
+		}
+		if profile.SpeedKmh > 0 {
+			speedKmh = profile.SpeedKmh
+		}
+		routeOptions = profile.RouteOptions()
+	}
+	departAt, err := ParseDepartAt(request.DepartAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid depart_at", Message: err.Error()})
+		return
+	}
+
+	// ノード: 0 = 出発地, 1..n = 立ち寄り先, n+1 = 到着地(指定時のみ)
+	nodes := append(append(append([]Coordinate{}, start...), stops...), end...)
+	ctx := c.Request.Context()
+	matrix, err := ComputeBicycleMatrix(ctx, nodes, nodes, speedKmh)
+	if err != nil {
+		respondMatrixError(c, err)
+		return
+	}
+
+	order := solveErrandOrder(matrix.WeightedDurations, len(stops), len(end) > 0)
+
+	response := ErrandsResponse{Order: make([]int, len(order))}
+	waypoints := []Coordinate{start[0]}
+	previous := 0
+	for i, node := range order {
+		response.Order[i] = node - 1
+		response.Duration += matrix.Durations[previous][node]
+		response.HazardExposure += matrix.HazardExposure[previous][node]
+		waypoints = append(waypoints, nodes[node])
+		previous = node
+	}
+	if len(end) > 0 {
+		last := len(nodes) - 1
+		response.Duration += matrix.Durations[previous][last]
+		response.HazardExposure += matrix.HazardExposure[previous][last]
+		waypoints = append(waypoints, end[0])
+	}
+
+	routeOptions, avoidedRestrictions := withActiveRestrictions(routeOptions, waypoints, departAt)
+	status, orsResp := requestDirections(ctx, waypoints, "", "", routeOptions)
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", RetryAfterSeconds(ORSUpstream()))
+	}
+	route, ok := orsResp.(DirectionsResponse)
+	if !ok {
+		c.JSON(status, orsResp)
+		return
+	}
+	route.AvoidedRestrictions = avoidedRestrictions
+	route.WarningPoints = RouteWarningPoints(route.Features[0], MessageLanguage(c))
+	route.SessoinID = GenerateSessionID()
+	SaveSessionGeometry(route.SessoinID, route.Features[0].Geometry)
+	response.Route = route
+	response.SessionID = route.SessoinID
+
+	c.JSON(http.StatusOK, response)
+}
+
+// solveErrandOrder は出発地(0)から立ち寄り先(1..stops)を全て回る順番を返す
+// hasEnd の場合は最後に到着地(stops+1)へ向かうコストも含めて最小化する
+func solveErrandOrder(cost [][]float64, stops int, hasEnd bool) []int {
+	if stops == 0 {
+		return []int{}
+	}
+	if stops <= maxExactErrandStops {
+		return solveErrandOrderExact(cost, stops, hasEnd)
+	}
+	return solveErrandOrderHeuristic(cost, stops, hasEnd)
+}
+
+// errandPathCost は順番 order で回った場合のコスト
+func errandPathCost(cost [][]float64, order []int, hasEnd bool) float64 {
+	total := 0.0
+	previous := 0
+	for _, node := range order {
+		total += cost[previous][node]
+		previous = node
+	}
+	if hasEnd {
+		total += cost[previous][len(order)+1]
+	}
+	return total
+}
+
+// solveErrandOrderExact は Held-Karp 法で最適な順番を求める
+func solveErrandOrderExact(cost [][]float64, stops int, hasEnd bool) []int {
+	full := 1 << stops
+	// best[mask][i] は mask の立ち寄り先を回って i (0始まり) で終わる最小コスト
+	best := make([][]float64, full)
+	parent := make([][]int, full)
+	for mask := range best {
+		best[mask] = make([]float64, stops)
+		parent[mask] = make([]int, stops)
+		for i := range best[mask] {
+			best[mask][i] = math.Inf(1)
+			parent[mask][i] = -1
+		}
+	}
+	for i := 0; i < stops; i++ {
+		best[1<<i][i] = cost[0][i+1]
+	}
+
+	for mask := 1; mask < full; mask++ {
+		for last := 0; last < stops; last++ {
+			if mask&(1<<last) == 0 || math.IsInf(best[mask][last], 1) {
+				continue
+			}
+			for next := 0; next < stops; next++ {
+				if mask&(1<<next) != 0 {
+					continue
+				}
+				nextMask := mask | 1<<next
+				candidate := best[mask][last] + cost[last+1][next+1]
+				if candidate < best[nextMask][next] {
+					best[nextMask][next] = candidate
+					parent[nextMask][next] = last
+				}
+			}
+		}
+	}
+
+	last := 0
+	bestCost := math.Inf(1)
+	for i := 0; i < stops; i++ {
+		total := best[full-1][i]
+		if hasEnd {
+			total += cost[i+1][stops+1]
+		}
+		if total < bestCost {
+			bestCost = total
+			last = i
+		}
+	}
+
+	order := make([]int, stops)
+	mask := full - 1
+	for k := stops - 1; k >= 0; k-- {
+		order[k] = last + 1
+		previous := parent[mask][last]
+		mask &^= 1 << last
+		last = previous
+	}
+	return order
+}
+
+// solveErrandOrderHeuristic は最近傍法で初期解を作り 2-opt で改善する
+func solveErrandOrderHeuristic(cost [][]float64, stops int, hasEnd bool) []int {
+	visited := make([]bool, stops+1)
+	order := make([]int, 0, stops)
+	current := 0
+	for len(order) < stops {
+		next := -1
+		for candidate := 1; candidate <= stops; candidate++ {
+			if !visited[candidate] && (next == -1 || cost[current][candidate] < cost[current][next]) {
+				next = candidate
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		current = next
+	}
+
+	// 行列は非対称なので区間を反転するたびに全体のコストで比較する
+	bestCost := errandPathCost(cost, order, hasEnd)
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < stops-1; i++ {
+			for j := i + 1; j < stops; j++ {
+				candidate := append([]int{}, order...)
+				for l, r := i, j; l < r; l, r = l+1, r-1 {
+					candidate[l], candidate[r] = candidate[r], candidate[l]
+				}
+				if candidateCost := errandPathCost(cost, candidate, hasEnd); candidateCost < bestCost-1e-9 {
+					order = candidate
+					bestCost = candidateCost
+					improved = true
+				}
+			}
+		}
+	}
+	return order
+}
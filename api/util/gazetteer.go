@@ -0,0 +1,291 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"template-mobile-app-api/jpnorm"
+)
+
+// GazetteerEntry はローカルの地名辞書の1件
+type GazetteerEntry struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Category   string    `json:"category"`          // bus_stop, warning_intersection, intersection, station, neighbourhood, POIのカテゴリなど
+	Coordinate []float64 `json:"coordinate"`        // [経度, 緯度]
+	Aliases    []string  `json:"aliases,omitempty"` // 読み(かな)・ローマ字・英語名・別名
+	Address    string    `json:"address,omitempty"` // 表示用の住所
+}
+
+// gazetteerIndex は地名辞書と検索用に正規化したキー
+type gazetteerIndex struct {
+	entries  []GazetteerEntry
+	keys     [][]string // entries と同じ順の、名前・別名を jpnorm.Fold したもの
+	readings [][]string // entries と同じ順の、かなの読みを jpnorm.LooseKana したもの
+}
+
+// gazetteerExtract は prepare_poi で作る OSM の地名・住所データ (GAZETTEER_FILE, デフォルト data/gazetteer.json)
+var gazetteerExtract = newDataset("gazetteer", "GAZETTEER_FILE", "data/gazetteer.json", parseJSONDataset(func(e GazetteerEntry) error {
+	if len(e.Coordinate) == 0 {
+		return nil
+	}
+	return validateCoordinate(e.Coordinate)
+}))
+
+// 地名辞書はデータセットを読み込み直したら次に使うときに作り直す
+var gazetteer = newDerived(func() *gazetteerIndex {
+	return newGazetteerIndex(buildGazetteer())
+})
+
+// 読みとして使う OSM のタグ
+var osmReadingTags = []string{"name:ja-Hira", "name:ja_kana", "name:ja-Hrkt", "name:ja_rm", "name:ja-Latn", "name:en", "alt_name", "short_name", "official_name"}
+
+// Gazetteer は手元のデータから作った地名辞書を返す
+//
+// バス停・取締強化交差点・違反率の交差点・地点データに加え、
+// prepare-data/prepare_poi で作る OSM の地名・住所データ(GAZETTEER_FILE, デフォルト data/gazetteer.json)を読み込む。
+func Gazetteer() []GazetteerEntry {
+	return loadGazetteer().entries
+}
+
+func loadGazetteer() *gazetteerIndex {
+	return gazetteer.get()
+}
+
+func buildGazetteer() []GazetteerEntry {
+	var entries []GazetteerEntry
+
+	// バス停は標柱ごとにデータがあるので名前ごとに1件にまとめる
+	seen := map[string]bool{}
+	for _, b := range busStops.Get() {
+		if b.Name == "" || seen[b.Name] {
+			continue
+		}
+		seen[b.Name] = true
+		entries = append(entries, GazetteerEntry{
+			ID:         b.ID,
+			Name:       b.Name,
+			Category:   "bus_stop",
+			Coordinate: []float64{b.Longitude, b.Latitude},
+			Aliases:    nonEmpty(b.Kana, b.NameEn),
+		})
+	}
+
+	for i, w := range WarningIntersections() {
+		if w.Name == "" || len(w.Coordinate) != 2 {
+			continue
+		}
+		entries = append(entries, GazetteerEntry{
+			ID:         "warning_intersection." + strconv.Itoa(i),
+			Name:       w.Name,
+			Category:   "warning_intersection",
+			Coordinate: w.Coordinate,
+			Aliases:    nonEmpty(strings.TrimSuffix(w.Name, "付近")),
+		})
+	}
+
+	// 違反率の交差点は「外堀通り×第一京浜」のような道路名の組み合わせ
+	for i, v := range ViolationRates() {
+		if v.Name == "" || len(v.Coordinate) != 2 {
+			continue
+		}
+		var aliases []string
+		if roads := strings.FieldsFunc(v.Name, func(r rune) bool { return r == '×' || r == 'Ｘ' }); len(roads) == 2 {
+			aliases = append(aliases, roads[0]+" "+roads[1], roads[1]+" "+roads[0])
+		}
+		entries = append(entries, GazetteerEntry{
+			ID:         "intersection." + strconv.Itoa(i),
+			Name:       v.Name,
+			Category:   "intersection",
+			Coordinate: v.Coordinate,
+			Aliases:    aliases,
+		})
+	}
+
+	for _, p := range POIs() {
+		if p.Name == "" || len(p.Coordinate) != 2 {
+			continue
+		}
+		var aliases []string
+		for _, tag := range osmReadingTags {
+			if v := p.Tags[tag]; v != "" {
+				aliases = append(aliases, v)
+			}
+		}
+		entries = append(entries, GazetteerEntry{
+			ID:         p.ID,
+			Name:       p.Name,
+			Category:   p.Category,
+			Coordinate: p.Coordinate,
+			Aliases:    aliases,
+		})
+	}
+
+	for _, e := range gazetteerExtract.Get() {
+		if e.Name != "" && len(e.Coordinate) == 2 {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+func newGazetteerIndex(entries []GazetteerEntry) *gazetteerIndex {
+	index := &gazetteerIndex{
+		entries:  entries,
+		keys:     make([][]string, len(entries)),
+		readings: make([][]string, len(entries)),
+	}
+	for i, e := range entries {
+		for _, name := range append([]string{e.Name}, e.Aliases...) {
+			key := jpnorm.Fold(name)
+			if key == "" {
+				continue
+			}
+			index.keys[i] = append(index.keys[i], key)
+			if jpnorm.IsKana(key) {
+				index.readings[i] = append(index.readings[i], jpnorm.LooseKana(key))
+			} else if jpnorm.IsRomaji(name) {
+				if kana, ok := jpnorm.RomajiToKatakana(name); ok {
+					index.readings[i] = append(index.readings[i], jpnorm.LooseKana(kana))
+				}
+			}
+		}
+	}
+	return index
+}
+
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// 一致の度合い (小さいほど良い)
+const (
+	matchExact = iota
+	matchPrefix
+	matchContains
+	matchNone
+)
+
+func matchKey(keys []string, query string) int {
+	best := matchNone
+	for _, key := range keys {
+		switch {
+		case key == query:
+			return matchExact
+		case strings.HasPrefix(key, query):
+			best = min(best, matchPrefix)
+		case strings.Contains(key, query):
+			best = min(best, matchContains)
+		}
+	}
+	return best
+}
+
+// gazetteerMatch は検索にヒットした地名と一致の度合い
+type gazetteerMatch struct {
+	entry    GazetteerEntry
+	rank     int
+	size     int     // 名前の長さ
+	distance float64 // 検索の基準の地点からの距離(m)
+}
+
+// search は表記ゆれを吸収して地名辞書を検索する
+//
+// 名前・別名は jpnorm.Fold で全角半角・ひらがなカタカナ・丁目番地の表記を揃えて比較し、
+// かな・ローマ字の入力は読みとも比較する。
+func (g *gazetteerIndex) search(query string, options SearchOptions) []gazetteerMatch {
+	folded := jpnorm.Fold(query)
+	if folded == "" {
+		return nil
+	}
+	var reading string
+	if jpnorm.IsKana(folded) {
+		reading = jpnorm.LooseKana(folded)
+	} else if jpnorm.IsRomaji(query) {
+		if kana, ok := jpnorm.RomajiToKatakana(query); ok {
+			reading = jpnorm.LooseKana(kana)
+		}
+	}
+
+	var matches []gazetteerMatch
+	for i, e := range g.entries {
+		if !options.contains(e.Coordinate[0], e.Coordinate[1]) || !options.matchesCategory(e.Category) {
+			continue
+		}
+		rank := matchKey(g.keys[i], folded)
+		if reading != "" {
+			rank = min(rank, matchKey(g.readings[i], reading))
+		}
+		if rank == matchNone {
+			continue
+		}
+		m := gazetteerMatch{entry: e, rank: rank, size: len([]rune(e.Name))}
+		if options.Focus != nil {
+			m.distance = haversineMeters(options.Focus[:], e.Coordinate)
+		}
+		matches = append(matches, m)
+	}
+	// 一致の度合いが同じなら近いもの、短い名前のものを優先する
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].size < matches[j].size
+	})
+	return matches
+}
+
+// gazetteerGeocoder は地名辞書を検索する
+type gazetteerGeocoder struct{}
+
+func (g *gazetteerGeocoder) Name() string { return "gazetteer" }
+
+func (g *gazetteerGeocoder) Search(ctx context.Context, query string, options SearchOptions) ([]SearchResponse, error) {
+	limit := options.candidates()
+	results := []SearchResponse{}
+	// 同じ場所が複数のデータに含まれている場合は1件にまとめる
+	seen := map[string]bool{}
+	for _, m := range loadGazetteer().search(query, options) {
+		if len(results) >= limit {
+			break
+		}
+		key := fmt.Sprintf("%s|%.4f,%.4f", jpnorm.Fold(m.entry.Name), m.entry.Coordinate[0], m.entry.Coordinate[1])
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		results = append(results, m.entry.SearchResponse())
+	}
+	return results, nil
+}
+
+// SearchResponse は地名辞書の1件を検索結果の形にする
+func (e GazetteerEntry) SearchResponse() SearchResponse {
+	displayName := e.Name
+	if e.Address != "" {
+		displayName = e.Name + ", " + e.Address
+	}
+	return SearchResponse{
+		ID:          e.ID,
+		Lat:         strconv.FormatFloat(e.Coordinate[1], 'f', -1, 64),
+		Lon:         strconv.FormatFloat(e.Coordinate[0], 'f', -1, 64),
+		Class:       "gazetteer",
+		Type:        e.Category,
+		Name:        e.Name,
+		DisplayName: displayName,
+		Source:      "gazetteer",
+	}
+}
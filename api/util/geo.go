@@ -0,0 +1,87 @@
+package util
+
+import "math"
+
+// 地球の平均半径(m)
+const earthRadiusMeters = 6371000.0
+
+// haversineMeters は [経度, 緯度] の2点間の大円距離(m)を返す
+func haversineMeters(a, b []float64) float64 {
+	lat1 := a[1] * math.Pi / 180
+	lat2 := b[1] * math.Pi / 180
+	dLat := (b[1] - a[1]) * math.Pi / 180
+	dLon := (b[0] - a[0]) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Atan2(math.Sqrt(h), math.Sqrt(1-h))
+}
+
+// toLocalMeters は origin を原点とした平面座標(m)に変換する (数km程度の範囲で十分な精度の正距円筒近似)
+func toLocalMeters(origin, p []float64) (x, y float64) {
+	x = (p[0] - origin[0]) * math.Pi / 180 * earthRadiusMeters * math.Cos(origin[1]*math.Pi/180)
+	y = (p[1] - origin[1]) * math.Pi / 180 * earthRadiusMeters
+	return x, y
+}
+
+// angularDistance は [経度, 緯度] の2点間の中心角(rad)を返す
+func angularDistance(a, b []float64) float64 {
+	return haversineMeters(a, b) / earthRadiusMeters
+}
+
+// initialBearing は a から b へ向かう大円の初期方位(rad, 北から時計回り)を返す
+func initialBearing(a, b []float64) float64 {
+	lat1 := a[1] * math.Pi / 180
+	lat2 := b[1] * math.Pi / 180
+	dLon := (b[0] - a[0]) * math.Pi / 180
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Atan2(y, x)
+}
+
+// pointSegmentDistanceMeters は点 p から線分 a-b (大円の弧) までの距離(m)と、線分上の射影位置 t (0-1, 弧の長さの割合) を返す
+//
+// 球面上のクロストラック距離・アロングトラック距離で求めるので、線分が長くても東西方向の距離が歪まない。
+// 射影が線分の外になる場合は近い方の端点までの距離を返す。
+func pointSegmentDistanceMeters(p, a, b []float64) (distance float64, t float64) {
+	segment := angularDistance(a, b)
+	toPoint := angularDistance(a, p)
+	if segment == 0 || toPoint == 0 {
+		return toPoint * earthRadiusMeters, 0
+	}
+	theta := initialBearing(a, p) - initialBearing(a, b)
+	crossTrack := math.Asin(math.Sin(toPoint) * math.Sin(theta))
+	// 直角球面三角形の tan(アロングトラック) = tan(a-p の中心角) cos(θ) を atan2 で解く (acos と違い短い距離でも桁落ちしない)
+	alongTrack := math.Atan2(math.Sin(toPoint)*math.Cos(theta), math.Cos(toPoint))
+
+	switch t = alongTrack / segment; {
+	case t <= 0:
+		return toPoint * earthRadiusMeters, 0
+	case t >= 1:
+		return haversineMeters(p, b), 1
+	}
+	return math.Abs(crossTrack) * earthRadiusMeters, t
+}
+
+// pointOnSegment は線分 a-b (大円の弧) 上の、a から弧の長さの割合 t の位置を返す
+func pointOnSegment(a, b []float64, t float64) []float64 {
+	delta := angularDistance(a, b)
+	if delta == 0 || t <= 0 {
+		return []float64{a[0], a[1]}
+	}
+	if t >= 1 {
+		return []float64{b[0], b[1]}
+	}
+	lat1, lon1 := a[1]*math.Pi/180, a[0]*math.Pi/180
+	lat2, lon2 := b[1]*math.Pi/180, b[0]*math.Pi/180
+	// 大円上の球面線形補間
+	wa := math.Sin((1-t)*delta) / math.Sin(delta)
+	wb := math.Sin(t*delta) / math.Sin(delta)
+	x := wa*math.Cos(lat1)*math.Cos(lon1) + wb*math.Cos(lat2)*math.Cos(lon2)
+	y := wa*math.Cos(lat1)*math.Sin(lon1) + wb*math.Cos(lat2)*math.Sin(lon2)
+	z := wa*math.Sin(lat1) + wb*math.Sin(lat2)
+	return []float64{
+		math.Atan2(y, x) * 180 / math.Pi,
+		math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi,
+	}
+}
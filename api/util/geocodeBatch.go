@@ -0,0 +1,248 @@
+package util
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"template-mobile-app-api/jpnorm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 一致の種類
+const (
+	MatchAddress      = "address"      // 番地・丁目まで含めた検索語で見つかった
+	MatchChome        = "chome"        // 番・号を除き、丁目までで見つかった
+	MatchLocality     = "locality"     // 町名だけで見つかった
+	MatchIntersection = "intersection" // 「外堀通り×第一京浜」のような交差点名で見つかった
+)
+
+// 一致の種類ごとの確からしさ (Queries の粗さの順)
+var matchConfidence = map[string]float64{
+	MatchAddress:      1.0,
+	MatchChome:        0.7,
+	MatchLocality:     0.4,
+	MatchIntersection: 0.9,
+}
+
+// 区市町村が分からず結果を確かめられない場合に掛ける係数
+const unverifiedWardFactor = 0.8
+
+// ErrLocationNotFound はどの検索語でも地点が見つからなかった場合のエラー
+var ErrLocationNotFound = errors.New("location not found")
+
+// GeocodeBatchRow は一括ジオコーディングの入力の1行
+type GeocodeBatchRow struct {
+	ID    string `json:"id" example:"1"`               // 行の識別子 (省略時は行番号)
+	Query string `json:"query" example:"千代田区永田町一丁目付近"` // 地名・住所
+}
+
+// GeocodeBatchRequest は一括ジオコーディングのリクエスト (JSON)
+type GeocodeBatchRequest struct {
+	Rows []GeocodeBatchRow `json:"rows" binding:"required"`
+}
+
+// GeocodeResult は1行のジオコーディング結果
+type GeocodeResult struct {
+	ID              string    `json:"id"`
+	Query           string    `json:"query"`            // 入力された地名
+	NormalizedQuery string    `json:"normalized_query"` // 実際に見つかった検索語
+	Coordinate      []float64 `json:"coordinate"`       // [経度, 緯度]
+	DisplayName     string    `json:"display_name"`
+	Source          string    `json:"source"`     // 結果を返したジオコーダ
+	MatchType       string    `json:"match_type"` // address, chome, locality, intersection
+	Confidence      float64   `json:"confidence"` // 0-1
+}
+
+// GeocodeUnresolved は座標が見つからなかった行
+type GeocodeUnresolved struct {
+	ID     string `json:"id"`
+	Query  string `json:"query"`
+	Reason string `json:"reason"`
+}
+
+// GeocodeBatchResponse は一括ジオコーディングの結果
+type GeocodeBatchResponse struct {
+	Results    []GeocodeResult     `json:"results"`
+	Unresolved []GeocodeUnresolved `json:"unresolved"`
+}
+
+// GetGeocodeBatch godoc
+// @Summary 一括ジオコーディング
+// @Description オープンデータの地名・住所をまとめて座標にする。JSON ({"rows": [{"id", "query"}]}) または CSV (Content-Type: text/csv, 1行目は見出しで id・query 列を使う) を受け付ける。/search と同じ予算・キャッシュのジオコーダを1行ずつ使い、行ごとの一致の種類と確からしさ、見つからなかった行の一覧を返す
+// @Tags map
+// @Accept json
+// @Accept plain
+// @Produce json
+// @Param request body GeocodeBatchRequest true "地名の一覧"
+// @Param column query string false "CSV の地名の列名 (デフォルト query)"
+// @Success 200 {object} GeocodeBatchResponse
+// @Failure 400 {object} ErrorResponse "リクエスト不正・行数超過"
+// @Router /geocode/batch [post]
+func GetGeocodeBatch(c *gin.Context) {
+	var rows []GeocodeBatchRow
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		parsed, err := ParseGeocodeCSV(c.Request.Body, c.DefaultQuery("column", "query"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid CSV", Message: err.Error()})
+			return
+		}
+		rows = parsed
+	} else {
+		var request GeocodeBatchRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+			return
+		}
+		rows = request.Rows
+	}
+	if maxRows := getEnvInt("GEOCODE_BATCH_MAX_ROWS", 500); len(rows) > maxRows {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Too many rows", Message: fmt.Sprintf("at most %d rows are allowed", maxRows)})
+		return
+	}
+	c.JSON(http.StatusOK, GeocodeBatch(c.Request.Context(), rows))
+}
+
+// ParseGeocodeCSV は CSV を行の一覧にする
+// 1行目は見出しで、column 列を地名、id 列 (無ければ行番号) を識別子にする
+func ParseGeocodeCSV(r io.Reader, column string) ([]GeocodeBatchRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	queryIndex, idIndex := -1, -1
+	for i, h := range header {
+		switch strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")) {
+		case column:
+			queryIndex = i
+		case "id":
+			idIndex = i
+		}
+	}
+	if queryIndex < 0 {
+		return nil, fmt.Errorf("column %q not found in header", column)
+	}
+
+	var rows []GeocodeBatchRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		row := GeocodeBatchRow{ID: strconv.Itoa(line)}
+		if queryIndex < len(record) {
+			row.Query = strings.TrimSpace(record[queryIndex])
+		}
+		if idIndex >= 0 && idIndex < len(record) && record[idIndex] != "" {
+			row.ID = record[idIndex]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// GeocodeBatch は行ごとに GeocodeLocation を呼ぶ
+// 同じ地名は1回だけ検索し、途中でリクエストが取り消された場合は残りを未解決にする
+func GeocodeBatch(ctx context.Context, rows []GeocodeBatchRow) GeocodeBatchResponse {
+	response := GeocodeBatchResponse{Results: []GeocodeResult{}, Unresolved: []GeocodeUnresolved{}}
+	type outcome struct {
+		result GeocodeResult
+		err    error
+	}
+	resolved := map[string]outcome{}
+	for i, row := range rows {
+		if row.ID == "" {
+			row.ID = strconv.Itoa(i + 1)
+		}
+		if strings.TrimSpace(row.Query) == "" {
+			response.Unresolved = append(response.Unresolved, GeocodeUnresolved{ID: row.ID, Query: row.Query, Reason: "empty query"})
+			continue
+		}
+		o, ok := resolved[row.Query]
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				o.err = err
+			} else {
+				o.result, o.err = GeocodeLocation(ctx, row.Query)
+				// 一時的なエラーは同じ地名の次の行で再び試す
+				if o.err == nil || errors.Is(o.err, ErrLocationNotFound) {
+					resolved[row.Query] = o
+				}
+			}
+		}
+		if o.err != nil {
+			response.Unresolved = append(response.Unresolved, GeocodeUnresolved{ID: row.ID, Query: row.Query, Reason: o.err.Error()})
+			continue
+		}
+		result := o.result
+		result.ID = row.ID
+		response.Results = append(response.Results, result)
+	}
+	return response
+}
+
+// GeocodeLocation はオープンデータの地名を座標にする
+//
+// jpnorm.ParseLocation で分解し、番地まで含めた住所 → 丁目まで → 町名だけの順に検索する。
+// 区市町村が分かる場合は、その区市町村内の結果だけを採用する。
+func GeocodeLocation(ctx context.Context, query string) (GeocodeResult, error) {
+	location := jpnorm.ParseLocation(query)
+	for _, q := range location.Queries() {
+		results, err := GetSearchBase(ctx, q, SearchOptions{})
+		if err != nil {
+			return GeocodeResult{}, err
+		}
+		for _, r := range results {
+			if location.Ward != "" && !strings.Contains(r.DisplayName, location.Ward) {
+				continue
+			}
+			coordinate, err := r.Coordinate()
+			if err != nil {
+				continue
+			}
+			matchType := locationMatchType(location, q)
+			confidence := matchConfidence[matchType]
+			if location.Ward == "" {
+				confidence *= unverifiedWardFactor
+			}
+			return GeocodeResult{
+				Query:           query,
+				NormalizedQuery: q,
+				Coordinate:      []float64{coordinate[0], coordinate[1]},
+				DisplayName:     r.DisplayName,
+				Source:          r.Source,
+				MatchType:       matchType,
+				Confidence:      math.Round(confidence*100) / 100,
+			}, nil
+		}
+	}
+	if location.Ward != "" {
+		return GeocodeResult{}, fmt.Errorf("%w in %s", ErrLocationNotFound, location.Ward)
+	}
+	return GeocodeResult{}, ErrLocationNotFound
+}
+
+// locationMatchType は Queries のどの粗さの検索語で見つかったかを返す
+func locationMatchType(location jpnorm.Location, q string) string {
+	switch {
+	case len(location.Roads) >= 2:
+		return MatchIntersection
+	case q == location.Query():
+		return MatchAddress
+	case strings.HasSuffix(q, "丁目"):
+		return MatchChome
+	}
+	return MatchLocality
+}
@@ -0,0 +1,416 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"template-mobile-app-api/httpclient"
+	"template-mobile-app-api/osm"
+	"time"
+)
+
+// Geocoder は地名・住所から地点を検索するサービス
+//
+// options のうち対応していないもの (Nominatim 以外の language など) は無視してよい。
+// 範囲(bounded)・カテゴリの絞り込みと件数の上限は各実装で守ること。
+type Geocoder interface {
+	Name() string
+	Search(ctx context.Context, query string, options SearchOptions) ([]SearchResponse, error)
+}
+
+// SearchOptions は検索の条件
+type SearchOptions struct {
+	Focus      *Coordinate `json:"focus,omitempty"`      // ライダーの現在地など、近い順に並べる基準の地点 [経度, 緯度]
+	Radius     float64     `json:"radius,omitempty"`     // Focus からの範囲(m)。Bounded なら範囲外を除き、そうでなければ優先するだけ
+	Viewbox    *[4]float64 `json:"viewbox,omitempty"`    // 検索範囲 [西, 南, 東, 北]
+	Bounded    bool        `json:"bounded,omitempty"`    // 範囲外の結果を除く
+	Limit      int         `json:"limit,omitempty"`      // 件数の上限 (デフォルト 5)
+	Categories []string    `json:"categories,omitempty"` // 地点の種類 (convenience, bicycle_parking, station など)
+	Language   string      `json:"language,omitempty"`   // 結果の言語 (デフォルト ja)
+}
+
+// Focus・Radius から範囲を作るときの Radius のデフォルト(m)
+const defaultSearchRadius = 5000.0
+
+// 件数の上限
+const maxSearchLimit = 50
+
+// ローカルの地名辞書で見つかる入力 (かな・ローマ字・丁目など) は外部APIに問い合わせずに返す
+const defaultGeocoderOrder = "gazetteer,nominatim_self_hosted,nominatim,gsi"
+
+// 1つのジオコーダから返す件数のデフォルト
+const defaultSearchLimit = 5
+
+var (
+	geocodersOnce sync.Once
+	geocoders     []Geocoder
+
+	geocodeCacheOnce sync.Once
+	searchCache      *ttlCache[[]SearchResponse]
+	reverseCache     *ttlCache[ReverseResponse]
+)
+
+// initGeocodeCache は検索・逆ジオコーディングの結果のキャッシュを用意する
+// 同じ地点・同じ検索語は外部APIに問い合わせない
+func initGeocodeCache() {
+	geocodeCacheOnce.Do(func() {
+		ttl := getEnvDuration("GEOCODER_CACHE_TTL", 24*time.Hour)
+		size := getEnvInt("GEOCODER_CACHE_SIZE", 10000)
+		searchCache = newTTLCache[[]SearchResponse](ttl, size)
+		reverseCache = newTTLCache[ReverseResponse](ttl, size)
+	})
+}
+
+// Geocoders は GEOCODER_ORDER (カンマ区切り) の順に利用するジオコーダを返す
+// 自前の Nominatim は NOMINATIM_SELF_HOSTED_URL が設定されている場合のみ使う
+func Geocoders() []Geocoder {
+	geocodersOnce.Do(func() {
+		order := os.Getenv("GEOCODER_ORDER")
+		if order == "" {
+			order = defaultGeocoderOrder
+		}
+		for _, name := range strings.Split(order, ",") {
+			switch name = strings.TrimSpace(name); name {
+			case "nominatim":
+				baseURL := os.Getenv("NOMINATIM_URL")
+				if baseURL == "" {
+					baseURL = "https://nominatim.openstreetmap.org"
+				}
+				// 公開サーバーは利用規約に沿って osm クライアントを通す
+				geocoders = append(geocoders, &nominatimGeocoder{name: name, baseURL: baseURL, upstream: NominatimUpstream(), osm: osm.Default()})
+			case "nominatim_self_hosted":
+				baseURL := os.Getenv("NOMINATIM_SELF_HOSTED_URL")
+				if baseURL == "" {
+					continue
+				}
+				geocoders = append(geocoders, &nominatimGeocoder{name: name, baseURL: baseURL, upstream: SelfHostedNominatimUpstream()})
+			case "gsi":
+				geocoders = append(geocoders, &gsiGeocoder{})
+			case "gazetteer":
+				geocoders = append(geocoders, &gazetteerGeocoder{})
+			case "":
+			default:
+				fmt.Println("unknown geocoder:", name)
+			}
+		}
+	})
+	return geocoders
+}
+
+// Geocode はジオコーダを順に試し、最初に結果を返したものの結果を返す
+//
+// エラーまたは0件の場合は次のジオコーダを試す。
+// いずれかが0件で成功していれば空の結果を、全てエラーの場合は最後のエラーを返す。
+//
+// Focus が指定されている場合は Focus からの距離を付け、近い順に並べる。
+func Geocode(ctx context.Context, query string, options SearchOptions) ([]SearchResponse, error) {
+	initGeocodeCache()
+	key, _ := json.Marshal(options)
+	cacheKey := query + "?" + string(key)
+	if cached, ok := searchCache.Get(cacheKey); ok {
+		return cached, nil
+	}
+
+	var lastErr error
+	succeeded := false
+	for _, g := range Geocoders() {
+		results, err := g.Search(ctx, query, options)
+		if err != nil {
+			fmt.Printf("geocoder %s error: %v\n", g.Name(), err)
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		succeeded = true
+		if len(results) > 0 {
+			results = options.rank(results)
+			searchCache.Set(cacheKey, results)
+			return results, nil
+		}
+	}
+	if succeeded || lastErr == nil {
+		return []SearchResponse{}, nil
+	}
+	return nil, lastErr
+}
+
+// limit は件数の上限を返す
+func (o SearchOptions) limit() int {
+	if o.Limit > 0 {
+		return min(o.Limit, maxSearchLimit)
+	}
+	return defaultSearchLimit
+}
+
+// language は結果の言語を返す
+func (o SearchOptions) language() string {
+	if o.Language != "" {
+		return o.Language
+	}
+	return "ja"
+}
+
+// viewbox は Viewbox、無ければ Focus と Radius から作った範囲を返す
+func (o SearchOptions) viewbox() (box [4]float64, ok bool) {
+	if o.Viewbox != nil {
+		return *o.Viewbox, true
+	}
+	if o.Focus == nil {
+		return box, false
+	}
+	radius := o.Radius
+	if radius <= 0 {
+		radius = defaultSearchRadius
+	}
+	dLat := radius / earthRadiusMeters * 180 / math.Pi
+	dLon := dLat / math.Cos(o.Focus[1]*math.Pi/180)
+	return [4]float64{o.Focus[0] - dLon, o.Focus[1] - dLat, o.Focus[0] + dLon, o.Focus[1] + dLat}, true
+}
+
+// contains は Bounded の場合に範囲内かを判定する
+// Focus と Radius が指定されていれば円の範囲で判定する
+func (o SearchOptions) contains(lon, lat float64) bool {
+	if !o.Bounded {
+		return true
+	}
+	if o.Focus != nil && o.Radius > 0 && o.Viewbox == nil {
+		return haversineMeters(o.Focus[:], []float64{lon, lat}) <= o.Radius
+	}
+	box, ok := o.viewbox()
+	if !ok {
+		return true
+	}
+	return lon >= box[0] && lon <= box[2] && lat >= box[1] && lat <= box[3]
+}
+
+// matchesCategory はカテゴリの指定が無いか、いずれかに該当するかを判定する
+func (o SearchOptions) matchesCategory(values ...string) bool {
+	if len(o.Categories) == 0 {
+		return true
+	}
+	for _, c := range o.Categories {
+		for _, v := range values {
+			if c == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// candidates は並べ替えや絞り込みの前に外部APIから取得する件数
+// 近い順に並べる・カテゴリで絞り込む場合は多めに取得する
+func (o SearchOptions) candidates() int {
+	if o.Focus != nil || len(o.Categories) > 0 {
+		return max(o.limit(), 20)
+	}
+	return o.limit()
+}
+
+// rank は Focus からの距離を付けて近い順に並べ、件数の上限で切る
+func (o SearchOptions) rank(results []SearchResponse) []SearchResponse {
+	if o.Focus != nil {
+		for i := range results {
+			if c, err := results[i].Coordinate(); err == nil {
+				results[i].Distance = math.Round(haversineMeters(o.Focus[:], c[:]))
+			}
+		}
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	}
+	if len(results) > o.limit() {
+		results = results[:o.limit()]
+	}
+	return results
+}
+
+// fetchUpstream は予算を守って GET し、200 以外は HTTPStatusError として返す
+func fetchUpstream(ctx context.Context, u *Upstream, reqURL string) ([]byte, error) {
+	// 同じ検索が同時に来た場合は1回のリクエストにまとめる
+	return u.Do(ctx, reqURL, func(ctx context.Context) ([]byte, error) {
+		resp, err := httpclient.Default().Do(ctx, &httpclient.Request{
+			Method:      http.MethodGet,
+			URL:         reqURL,
+			BeforeRetry: u.Wait,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Body: resp.Body}
+		}
+		return resp.Body, nil
+	})
+}
+
+// =================Nominatim=================
+
+type nominatimGeocoder struct {
+	name     string
+	baseURL  string
+	upstream *Upstream
+	osm      *osm.Client // nil の場合は通常の HTTP クライアントで送る
+}
+
+// fetch は予算を守って GET する
+func (g *nominatimGeocoder) fetch(ctx context.Context, reqURL string) ([]byte, error) {
+	if g.osm == nil {
+		return fetchUpstream(ctx, g.upstream, reqURL)
+	}
+	// キャッシュにあれば Nominatim の予算を使わない
+	if body, ok := g.osm.Cached(reqURL); ok {
+		return body, nil
+	}
+	return g.upstream.Do(ctx, reqURL, func(ctx context.Context) ([]byte, error) {
+		body, err := g.osm.Get(ctx, reqURL)
+		var statusErr *osm.StatusError
+		if errors.As(err, &statusErr) {
+			return nil, &HTTPStatusError{StatusCode: statusErr.StatusCode, Body: statusErr.Body}
+		}
+		return body, err
+	})
+}
+
+// nominatimPlace は Nominatim の /search?format=json のレスポンス
+type nominatimPlace struct {
+	PlaceID     int64    `json:"place_id"`
+	Licence     string   `json:"licence"`
+	OsmType     string   `json:"osm_type"`
+	OsmID       int64    `json:"osm_id"`
+	Lat         string   `json:"lat"`
+	Lon         string   `json:"lon"`
+	Class       string   `json:"class"`
+	Type        string   `json:"type"`
+	PlaceRank   int      `json:"place_rank"`
+	Importance  float64  `json:"importance"`
+	AddressType string   `json:"addresstype"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	BoundingBox []string `json:"boundingbox"`
+}
+
+func (g *nominatimGeocoder) Name() string { return g.name }
+
+func (g *nominatimGeocoder) Search(ctx context.Context, query string, options SearchOptions) ([]SearchResponse, error) {
+	values := url.Values{}
+	values.Set("q", query)
+	values.Set("format", "json")
+	values.Set("accept-language", options.language())
+	values.Set("limit", strconv.Itoa(options.candidates()))
+	// viewbox は bounded でなければ優先する範囲として使われる
+	if box, ok := options.viewbox(); ok {
+		values.Set("viewbox", fmt.Sprintf("%f,%f,%f,%f", box[0], box[1], box[2], box[3]))
+		if options.Bounded {
+			values.Set("bounded", "1")
+		}
+	}
+	reqURL := strings.TrimRight(g.baseURL, "/") + "/search?" + values.Encode()
+
+	body, err := g.fetch(ctx, reqURL)
+	if err != nil {
+		return nil, err
+	}
+	var places []nominatimPlace
+	if err := json.Unmarshal(body, &places); err != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %w", g.name, err)
+	}
+
+	results := make([]SearchResponse, 0, len(places))
+	for _, p := range places {
+		if !options.matchesCategory(p.Type, p.Class) {
+			continue
+		}
+		result := SearchResponse{
+			PlaceID:     p.PlaceID,
+			ID:          g.name + "." + strconv.FormatInt(p.PlaceID, 10),
+			Licence:     p.Licence,
+			OsmType:     p.OsmType,
+			OsmID:       p.OsmID,
+			Lat:         p.Lat,
+			Lon:         p.Lon,
+			Class:       p.Class,
+			Type:        p.Type,
+			PlaceRank:   p.PlaceRank,
+			Importance:  p.Importance,
+			AddressType: p.AddressType,
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			BoundingBox: p.BoundingBox,
+			Source:      g.name,
+		}
+		if c, err := result.Coordinate(); err == nil && !options.contains(c[0], c[1]) {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// =================国土地理院 住所検索=================
+
+const gsiAddressSearchURL = "https://msearch.gsi.go.jp/address-search/AddressSearch"
+
+type gsiGeocoder struct{}
+
+// gsiFeature は国土地理院 住所検索APIのレスポンス (GeoJSON Feature の配列)
+type gsiFeature struct {
+	Geometry struct {
+		Coordinates []float64 `json:"coordinates"` // [経度, 緯度]
+	} `json:"geometry"`
+	Properties struct {
+		AddressCode string `json:"addressCode"`
+		Title       string `json:"title"`
+	} `json:"properties"`
+}
+
+func (g *gsiGeocoder) Name() string { return "gsi" }
+
+func (g *gsiGeocoder) Search(ctx context.Context, query string, options SearchOptions) ([]SearchResponse, error) {
+	// 住所のみなので施設の種類の指定がある場合は検索しない
+	if !options.matchesCategory("address") {
+		return []SearchResponse{}, nil
+	}
+	reqURL := gsiAddressSearchURL + "?q=" + url.QueryEscape(query)
+	body, err := fetchUpstream(ctx, GSIUpstream(), reqURL)
+	if err != nil {
+		return nil, err
+	}
+	var features []gsiFeature
+	if err := json.Unmarshal(body, &features); err != nil {
+		return nil, fmt.Errorf("failed to parse gsi response: %w", err)
+	}
+
+	limit := options.candidates()
+	results := []SearchResponse{}
+	for _, f := range features {
+		if len(results) >= limit {
+			break
+		}
+		if len(f.Geometry.Coordinates) < 2 || !options.contains(f.Geometry.Coordinates[0], f.Geometry.Coordinates[1]) {
+			continue
+		}
+		results = append(results, SearchResponse{
+			ID:          "gsi." + f.Properties.AddressCode + "." + f.Properties.Title,
+			Licence:     "出典: 国土地理院",
+			Lat:         strconv.FormatFloat(f.Geometry.Coordinates[1], 'f', -1, 64),
+			Lon:         strconv.FormatFloat(f.Geometry.Coordinates[0], 'f', -1, 64),
+			Class:       "place",
+			Type:        "address",
+			AddressType: "address",
+			Name:        f.Properties.Title,
+			DisplayName: f.Properties.Title,
+			Source:      g.Name(),
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"fmt"
+)
+
+// busStops はバス停 (BUS_STOPS_FILE, デフォルト data/bus_stops.json)
+var busStops = newDataset("bus_stops", "BUS_STOPS_FILE", "data/bus_stops.json", parseJSONDataset(func(b BusStop) error {
+	if b.ID == "" {
+		return fmt.Errorf("bus stop id is required")
+	}
+	return validateCoordinate([]float64{b.Longitude, b.Latitude})
+}))
+
+// 危険箇所・バス停の空間索引
+// データセットを読み込み直したら次に使うときに作り直す
+var (
+	violationRateIndex = newDerived(func() *SpatialIndex[ViolationRate] {
+		return NewSpatialIndex(ViolationRates(), func(v ViolationRate) []float64 { return v.Coordinate })
+	})
+	warningPointIndex = newDerived(func() *SpatialIndex[WarningPoint] {
+		return NewSpatialIndex(WarningPoints(), func(w WarningPoint) []float64 { return w.Coordinate })
+	})
+	busStopIndex = newDerived(func() *SpatialIndex[BusStop] {
+		return NewSpatialIndex(busStops.Get(), func(b BusStop) []float64 { return []float64{b.Longitude, b.Latitude} })
+	})
+)
+
+// ViolationRateIndex は違反率の交差点の索引を返す
+func ViolationRateIndex() *SpatialIndex[ViolationRate] {
+	return violationRateIndex.get()
+}
+
+// WarningPointIndex は取締強化交差点・事故多発地点と承認済みの報告の索引を返す
+func WarningPointIndex() *SpatialIndex[WarningPoint] {
+	return hazardReports().warningPointIndex(warningPointIndex.get())
+}
+
+// BusStopIndex はバス停の索引を返す
+// バス停のデータを読み込めなかった場合はそのエラーも返す
+func BusStopIndex() (*SpatialIndex[BusStop], error) {
+	return busStopIndex.get(), busStops.loadError()
+}
@@ -0,0 +1,349 @@
+package util
+
+import (
+	_ "embed"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// 注意のメッセージの言語 (テンプレートが無い言語はこの言語で返す)
+const defaultMessageLanguage = "ja"
+
+// 危険度の段階
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+// HazardMessageTemplate は注意点の種類・危険度ごとのメッセージのテンプレート
+//
+// 本文の {rate} などは値に置き換え、[...] の中は値が空の {…} があれば丸ごと省く。
+// 本文が複数ある場合は地点ごとに決まった1つを使う (同じ経路なら毎回同じメッセージになる)。
+type HazardMessageTemplate struct {
+	Type     string              `json:"type"`               // violation_rate, intersection, accident_hotspot, user_report, label (時間帯・報告の種類の表示名)
+	Tier     string              `json:"tier,omitempty"`     // low, medium, high (空はどの段階にも使う)。label の場合は名前
+	Title    map[string]string   `json:"title,omitempty"`    // 言語 -> 見出し
+	Messages map[string][]string `json:"messages,omitempty"` // 言語 -> 本文の候補
+}
+
+// 注意点の種類ごとに使えるプレースホルダー
+var hazardMessagePlaceholders = map[string][]string{
+	"violation_rate":       {"rate", "count", "place"},
+	"intersection":         {"reason", "reason_summary", "place"},
+	AccidentHotspotType:    {"count", "fatal", "serious", "years", "peak"},
+	ReportWarningPointType: {"label"},
+	"label":                nil,
+}
+
+var hazardMessagePlaceholder = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+// 組み込みのテンプレート (HAZARD_MESSAGES_FILE で種類・危険度・言語ごとに上書きできる)
+//
+//go:embed hazard_messages.json
+var defaultHazardMessagesJSON []byte
+
+// hazardMessageOverrides は組み込みのテンプレートを上書きするテンプレート (HAZARD_MESSAGES_FILE, デフォルト data/hazard_messages.json)
+// ファイルが無い場合は組み込みのテンプレートだけを使う。書き換えるとサーバーを再起動せずに読み込み直す
+var hazardMessageOverrides = newDataset("hazard_messages", "HAZARD_MESSAGES_FILE", "data/hazard_messages.json", parseJSONDataset(validateHazardMessageTemplate))
+
+func validateHazardMessageTemplate(t HazardMessageTemplate) error {
+	placeholders, ok := hazardMessagePlaceholders[t.Type]
+	if !ok {
+		return fmt.Errorf("unknown hazard type: %q", t.Type)
+	}
+	if len(t.Title) == 0 && len(t.Messages) == 0 {
+		return fmt.Errorf("%s/%s: title or messages is required", t.Type, t.Tier)
+	}
+	check := func(lang, text string) error {
+		if _, err := language.Parse(lang); err != nil {
+			return fmt.Errorf("%s/%s: invalid language %q", t.Type, t.Tier, lang)
+		}
+		if err := validateMessageTemplate(text, placeholders); err != nil {
+			return fmt.Errorf("%s/%s (%s): %w", t.Type, t.Tier, lang, err)
+		}
+		return nil
+	}
+	for lang, title := range t.Title {
+		if err := check(lang, title); err != nil {
+			return err
+		}
+	}
+	for lang, messages := range t.Messages {
+		if len(messages) == 0 {
+			return fmt.Errorf("%s/%s (%s): messages must not be empty", t.Type, t.Tier, lang)
+		}
+		for _, message := range messages {
+			if err := check(lang, message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateMessageTemplate は [...] の対応と、プレースホルダーが使えるものかを確かめる
+func validateMessageTemplate(text string, placeholders []string) error {
+	depth := 0
+	for _, r := range text {
+		switch r {
+		case '[':
+			if depth++; depth > 1 {
+				return fmt.Errorf("nested [ in %q", text)
+			}
+		case ']':
+			if depth--; depth < 0 {
+				return fmt.Errorf("unbalanced ] in %q", text)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("unclosed [ in %q", text)
+	}
+	for _, m := range hazardMessagePlaceholder.FindAllStringSubmatch(text, -1) {
+		if !slices.Contains(placeholders, m[1]) {
+			return fmt.Errorf("unknown placeholder {%s} in %q", m[1], text)
+		}
+	}
+	return nil
+}
+
+// hazardMessageCatalog は組み込みのテンプレートに上書きを重ねたもの
+type hazardMessageCatalog struct {
+	titles    map[string]map[string]string   // 種類/危険度 -> 言語 -> 見出し
+	messages  map[string]map[string][]string // 種類/危険度 -> 言語 -> 本文の候補
+	languages []string                       // defaultMessageLanguage が先頭
+	matcher   language.Matcher
+}
+
+var hazardMessages = newDerived(func() *hazardMessageCatalog {
+	defaults, err := parseJSONDataset(validateHazardMessageTemplate)(defaultHazardMessagesJSON)
+	if err != nil {
+		panic(fmt.Sprintf("hazard_messages.json: %v", err))
+	}
+	c := &hazardMessageCatalog{
+		titles:    map[string]map[string]string{},
+		messages:  map[string]map[string][]string{},
+		languages: []string{defaultMessageLanguage},
+	}
+	for _, t := range append(defaults, hazardMessageOverrides.Get()...) {
+		key := t.Type + "/" + t.Tier
+		for lang, title := range t.Title {
+			if c.titles[key] == nil {
+				c.titles[key] = map[string]string{}
+			}
+			c.titles[key][lang] = title
+			c.addLanguage(lang)
+		}
+		for lang, messages := range t.Messages {
+			if c.messages[key] == nil {
+				c.messages[key] = map[string][]string{}
+			}
+			c.messages[key][lang] = messages
+			c.addLanguage(lang)
+		}
+	}
+	tags := make([]language.Tag, len(c.languages))
+	for i, lang := range c.languages {
+		tags[i] = language.Make(lang)
+	}
+	c.matcher = language.NewMatcher(tags)
+	return c
+})
+
+func (c *hazardMessageCatalog) addLanguage(lang string) {
+	if !slices.Contains(c.languages, lang) {
+		c.languages = append(c.languages, lang)
+	}
+}
+
+// lookupTemplate は lang, defaultMessageLanguage の順に、種類・危険度、種類だけのテンプレートを探す
+func lookupTemplate[T any](entries map[string]map[string]T, lang, hazardType, tier string) (T, bool) {
+	for _, l := range []string{lang, defaultMessageLanguage} {
+		for _, key := range []string{hazardType + "/" + tier, hazardType + "/"} {
+			if v, ok := entries[key][l]; ok {
+				return v, true
+			}
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// title は見出しを返す。テンプレートが無い場合は空
+func (c *hazardMessageCatalog) title(lang, hazardType, tier string, values map[string]string) string {
+	title, ok := lookupTemplate(c.titles, lang, hazardType, tier)
+	if !ok {
+		return ""
+	}
+	return renderMessageTemplate(title, values)
+}
+
+// message は本文の候補から key (地点の座標など) で決まる1つを選んで返す。テンプレートが無い場合は空
+func (c *hazardMessageCatalog) message(lang, hazardType, tier, key string, values map[string]string) string {
+	messages, ok := lookupTemplate(c.messages, lang, hazardType, tier)
+	if !ok || len(messages) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return renderMessageTemplate(messages[h.Sum32()%uint32(len(messages))], values)
+}
+
+// label は時間帯・報告の種類などの表示名を返す。テンプレートが無い場合は空
+func (c *hazardMessageCatalog) label(lang, name string) string {
+	return c.message(lang, "label", name, "", nil)
+}
+
+// renderMessageTemplate は {名前} を values の値に置き換える
+// [...] の中に値が空のプレースホルダーがあれば [...] ごと省く
+func renderMessageTemplate(text string, values map[string]string) string {
+	fill := func(s string) (string, bool) {
+		complete := true
+		s = hazardMessagePlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+			v := values[m[1:len(m)-1]]
+			if v == "" {
+				complete = false
+			}
+			return v
+		})
+		return s, complete
+	}
+	var b strings.Builder
+	for text != "" {
+		open := strings.IndexByte(text, '[')
+		closing := strings.IndexByte(text, ']')
+		if open < 0 || closing < open {
+			s, _ := fill(text)
+			b.WriteString(s)
+			break
+		}
+		s, _ := fill(text[:open])
+		b.WriteString(s)
+		if s, ok := fill(text[open+1 : closing]); ok {
+			b.WriteString(s)
+		}
+		text = text[closing+1:]
+	}
+	return b.String()
+}
+
+// MessageLanguage は lang パラメータか Accept-Language ヘッダーから注意のメッセージの言語を選ぶ
+// テンプレートがある言語のうち最も近いものを返し、Content-Language ヘッダーに入れる
+func MessageLanguage(c *gin.Context) string {
+	catalog := hazardMessages.get()
+	var tags []language.Tag
+	if lang := c.Query("lang"); lang != "" {
+		tags = []language.Tag{language.Make(lang)}
+	} else {
+		tags, _, _ = language.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	}
+	lang := defaultMessageLanguage
+	if _, index, confidence := catalog.matcher.Match(tags...); confidence != language.No {
+		lang = catalog.languages[index]
+	}
+	c.Header("Content-Language", lang)
+	c.Header("Vary", "Accept-Language")
+	return lang
+}
+
+// hazardMessageKey は本文の候補を選ぶのに使う地点の文字列を返す
+func hazardMessageKey(coordinate []float64) string {
+	if len(coordinate) != 2 {
+		return ""
+	}
+	return hazardNameKey(coordinate)
+}
+
+// riskTier は 0-1 の値を危険度の段階にする
+func riskTier(value, medium, high float64) string {
+	switch {
+	case value >= high:
+		return RiskHigh
+	case value >= medium:
+		return RiskMedium
+	default:
+		return RiskLow
+	}
+}
+
+// countValue は件数をプレースホルダーの値にする (0件は空にして [...] を省く)
+func countValue(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprint(n)
+}
+
+// Localize は注意点のメッセージ (と利用者の報告の名前) を lang で作り直した注意点を返す
+func (p WarningPoint) Localize(lang string) WarningPoint {
+	catalog := hazardMessages.get()
+	switch p.Type {
+	case AccidentHotspotType:
+		if p.Accident != nil {
+			p.Message = accidentHotspotMessage(catalog, lang, p.Coordinate, *p.Accident)
+		}
+	case ReportWarningPointType:
+		if p.Report != nil {
+			p.Name, p.Message = reportWarningPointMessage(catalog, lang, *p.Report)
+		}
+	case "", defaultWarningPointType:
+		// 取締強化交差点は取締理由 (日本語のオープンデータ) と、その分類の表示名をテンプレートに埋め込む
+		values := map[string]string{
+			"reason":         p.Message,
+			"reason_summary": catalog.label(lang, enforcementReasonLabel(p.Message)),
+			"place":          p.Name,
+		}
+		if message := catalog.message(lang, "intersection", "", hazardMessageKey(p.Coordinate), values); message != "" {
+			p.Message = message
+		}
+	}
+	return p
+}
+
+// 取締理由の分類 (先に一致したもの)。表示名は label の reason_<分類> のテンプレート
+var enforcementReasons = []struct {
+	label    string
+	keywords []string
+}{
+	{"reason_fatal", []string{"死亡事故"}},
+	{"reason_serious", []string{"重傷事故"}},
+	{"reason_accidents", []string{"事故多発", "事故が多", "事故が多発", "事故、違反多発"}},
+	{"reason_construction", []string{"工事"}},
+	{"reason_school", []string{"小学校", "通学", "児童"}},
+	{"reason_pedestrians", []string{"歩行者", "横断"}},
+	{"reason_bus_lane", []string{"バスレーン", "路線バス"}},
+	{"reason_expressway", []string{"首都高", "自動車道"}},
+	{"reason_requests", []string{"取締り要望", "取締要望"}},
+	{"reason_traffic", []string{"交通量", "通行量", "通行が多", "通行も多", "通過車両"}},
+}
+
+// enforcementReasonLabel は取締理由の分類の label の名前を返す。分類できない場合は空
+func enforcementReasonLabel(reason string) string {
+	for _, r := range enforcementReasons {
+		for _, keyword := range r.keywords {
+			if strings.Contains(reason, keyword) {
+				return r.label
+			}
+		}
+	}
+	if reason != "" {
+		return "reason_other"
+	}
+	return ""
+}
+
+// LocalizeWarningPoints は注意点のメッセージを lang で作り直す
+func LocalizeWarningPoints(points []WarningPoint, lang string) []WarningPoint {
+	result := make([]WarningPoint, len(points))
+	for i, p := range points {
+		result[i] = p.Localize(lang)
+	}
+	return result
+}
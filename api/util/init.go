@@ -1,10 +0,0 @@
-package util
-
-var (
-	violationRates []ViolationRate
-)
-
-func init() {
-
-	violationRates, _ = LoadViolationRates("data/violation_rates.json")
-}
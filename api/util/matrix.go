@@ -0,0 +1,289 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"template-mobile-app-api/httpclient"
+)
+
+const (
+	OpenRouteServiceMatrixURL = "https://api.openrouteservice.org/v2/matrix/cycling-road"
+
+	// 1リクエストで受け付ける出発地・目的地の上限
+	maxMatrixOrigins      = 100
+	maxMatrixDestinations = 100
+
+	// 経路が取れなかった場合の推定に使う値
+	matrixDetourFactor    = 1.3  // 直線距離に対する道のりの比率
+	matrixDefaultSpeedKmh = 15.0 // プロファイル指定が無い場合の速度
+	// 危険箇所を数える際の直線からの距離(m)
+	matrixHazardCorridorMeters = 100.0
+)
+
+// 行列の値の出どころ
+const (
+	MatrixSourceORS           = "ors"            // ORSの経路
+	MatrixSourceNoRoute       = "no_route"       // ORSが経路を返さなかったため直線距離から推定
+	MatrixSourceUpstreamError = "upstream_error" // ORSに問い合わせられなかったため直線距離から推定
+)
+
+// MatrixRequest は /matrix/bicycle のリクエスト
+type MatrixRequest struct {
+	Origins      [][]float64 `json:"origins" binding:"required"`      // 出発地 [[経度, 緯度], ...]
+	Destinations [][]float64 `json:"destinations" binding:"required"` // 目的地 [[経度, 緯度], ...]
+	Profile      string      `json:"profile,omitempty" example:"commuter"`
+}
+
+// MatrixResponse は出発地 i から目的地 j への値を [i][j] に持つ
+type MatrixResponse struct {
+	Durations         [][]float64 `json:"durations"`          // 所要時間(秒)
+	Distances         [][]float64 `json:"distances"`          // 距離(m)
+	HazardExposure    [][]int     `json:"hazard_exposure"`    // 出発地と目的地を結ぶ直線付近の危険箇所の数
+	WeightedDurations [][]float64 `json:"weighted_durations"` // 危険箇所のペナルティを加えた所要時間(秒)
+	Estimated         [][]bool    `json:"estimated"`          // ORSから取得できず直線距離から推定した値かどうか
+	Sources           [][]string  `json:"sources"`            // 値の出どころ (ors, no_route, upstream_error)
+}
+
+// orsMatrixResponse は https://openrouteservice.org/dev/#/api-docs/v2/matrix/{profile}/post のレスポンス
+type orsMatrixResponse struct {
+	Durations [][]*float64 `json:"durations"`
+	Distances [][]*float64 `json:"distances"`
+}
+
+// GetBicycleMatrix godoc
+// @Summary 自転車の所要時間・距離行列
+// @Description 出発地と目的地の全組み合わせについて所要時間・距離・危険箇所の数を返す。ORSのmatrix APIを分割して呼び出し、取得できなかった組み合わせは直線距離から推定する (estimated, sources)。ORSから1つも取得できなかった場合はエラーを返す
+// @Tags map
+// @Accept json
+// @Produce json
+// @Param request body MatrixRequest true "出発地・目的地の座標リスト"
+// @Success 200 {object} MatrixResponse "所要時間・距離行列"
+// @Failure 400 {object} ErrorResponse "リクエストパラメータ不正"
+// @Failure 429 {object} ErrorResponse "ORSのリクエスト予算超過"
+// @Failure 502 {object} ErrorResponse "ORSから取得できない"
+// @Failure 503 {object} ErrorResponse "ORSが利用できない"
+// @Failure 504 {object} ErrorResponse "ORSがタイムアウト"
+// @Router /matrix/bicycle [post]
+func GetBicycleMatrix(c *gin.Context) {
+	var request MatrixRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	origins, err := toCoordinates(request.Origins, maxMatrixOrigins)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid origins", Message: err.Error()})
+		return
+	}
+	destinations, err := toCoordinates(request.Destinations, maxMatrixDestinations)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid destinations", Message: err.Error()})
+		return
+	}
+
+	speedKmh := matrixDefaultSpeedKmh
+	if request.Profile != "" {
+		profile, ok := LookupRiderProfile(request.Profile)
+		if !ok {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid profile", Message: fmt.Sprintf("unknown profile: %s", request.Profile)})
+			return
+		}
+		if profile.SpeedKmh > 0 {
+			speedKmh = profile.SpeedKmh
+		}
+	}
+
+	matrix, err := ComputeBicycleMatrix(c.Request.Context(), origins, destinations, speedKmh)
+	if err != nil {
+		respondMatrixError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, matrix)
+}
+
+// respondMatrixError は ORS から行列を1つも取得できなかったエラーを返す
+func respondMatrixError(c *gin.Context, err error) {
+	status := UpstreamStatus(err)
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", RetryAfterSeconds(ORSUpstream()))
+	}
+	c.JSON(status, ErrorResponse{Error: "Failed to fetch matrix", Message: err.Error()})
+}
+
+// toCoordinates は [[経度, 緯度], ...] を検証して Coordinate に変換する
+func toCoordinates(values [][]float64, limit int) ([]Coordinate, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("at least one coordinate is required")
+	}
+	if len(values) > limit {
+		return nil, fmt.Errorf("too many coordinates: %d (max %d)", len(values), limit)
+	}
+	coordinates := make([]Coordinate, len(values))
+	for i, v := range values {
+		if len(v) != 2 || v[0] < -180 || v[0] > 180 || v[1] < -90 || v[1] > 90 {
+			return nil, fmt.Errorf("coordinate %d must be [lon, lat]: %v", i, v)
+		}
+		coordinates[i] = Coordinate{v[0], v[1]}
+	}
+	return coordinates, nil
+}
+
+// ComputeBicycleMatrix は出発地×目的地の行列を計算する
+//
+// ORSの1リクエストあたりの上限に収まるように分割して問い合わせ、
+// 失敗したブロックや経路が見つからなかった組み合わせは直線距離×迂回係数と speedKmh から推定し、Estimated と Sources に記録する。
+// 全てのブロックが失敗した場合は推定値を返さず、最後のエラーを返す。
+func ComputeBicycleMatrix(ctx context.Context, origins, destinations []Coordinate, speedKmh float64) (MatrixResponse, error) {
+	result := MatrixResponse{
+		Durations:         make([][]float64, len(origins)),
+		Distances:         make([][]float64, len(origins)),
+		HazardExposure:    make([][]int, len(origins)),
+		WeightedDurations: make([][]float64, len(origins)),
+		Estimated:         make([][]bool, len(origins)),
+		Sources:           make([][]string, len(origins)),
+	}
+	for i := range origins {
+		result.Durations[i] = make([]float64, len(destinations))
+		result.Distances[i] = make([]float64, len(destinations))
+		result.HazardExposure[i] = make([]int, len(destinations))
+		result.WeightedDurations[i] = make([]float64, len(destinations))
+		result.Estimated[i] = make([]bool, len(destinations))
+		result.Sources[i] = make([]string, len(destinations))
+	}
+
+	maxLocations := getEnvInt("ORS_MATRIX_MAX_LOCATIONS", 50)
+	maxElements := getEnvInt("ORS_MATRIX_MAX_ELEMENTS", 3500)
+	originChunk := min(len(origins), max(1, maxLocations/2))
+	destinationChunk := min(len(destinations), max(1, maxLocations-originChunk), max(1, maxElements/originChunk))
+
+	var lastErr error
+	succeeded := false
+	for oStart := 0; oStart < len(origins); oStart += originChunk {
+		oEnd := min(oStart+originChunk, len(origins))
+		for dStart := 0; dStart < len(destinations); dStart += destinationChunk {
+			dEnd := min(dStart+destinationChunk, len(destinations))
+
+			block, err := requestORSMatrix(ctx, origins[oStart:oEnd], destinations[dStart:dEnd])
+			source := MatrixSourceNoRoute
+			if err != nil {
+				fmt.Println("matrix batch error:", err)
+				lastErr = err
+				source = MatrixSourceUpstreamError
+			} else {
+				succeeded = true
+			}
+			for i := oStart; i < oEnd; i++ {
+				for j := dStart; j < dEnd; j++ {
+					if block != nil && block.Durations[i-oStart][j-dStart] != nil && block.Distances[i-oStart][j-dStart] != nil {
+						result.Durations[i][j] = *block.Durations[i-oStart][j-dStart]
+						result.Distances[i][j] = *block.Distances[i-oStart][j-dStart]
+						result.Sources[i][j] = MatrixSourceORS
+						continue
+					}
+					distance := haversineMeters(origins[i][:], destinations[j][:]) * matrixDetourFactor
+					result.Distances[i][j] = distance
+					result.Durations[i][j] = distance / (speedKmh * 1000 / 3600)
+					result.Estimated[i][j] = true
+					result.Sources[i][j] = source
+				}
+			}
+		}
+	}
+
+	if !succeeded {
+		return MatrixResponse{}, lastErr
+	}
+
+	penalty := getEnvDuration("MATRIX_HAZARD_PENALTY", 30*time.Second).Seconds()
+	for i := range origins {
+		for j := range destinations {
+			exposure := countHazardsNearSegment(origins[i][:], destinations[j][:], matrixHazardCorridorMeters)
+			result.HazardExposure[i][j] = exposure
+			result.WeightedDurations[i][j] = result.Durations[i][j] + float64(exposure)*penalty
+		}
+	}
+	return result, nil
+}
+
+// requestORSMatrix は1ブロック分の行列をORSに問い合わせる
+func requestORSMatrix(ctx context.Context, origins, destinations []Coordinate) (*orsMatrixResponse, error) {
+	apiKey := os.Getenv("OPEN_ROUTE_SERVICE_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("OPEN_ROUTE_SERVICE_API_KEY is not set")
+	}
+
+	locations := append(append([]Coordinate{}, origins...), destinations...)
+	sources := make([]int, len(origins))
+	for i := range sources {
+		sources[i] = i
+	}
+	targets := make([]int, len(destinations))
+	for i := range targets {
+		targets[i] = len(origins) + i
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"locations":    locations,
+		"sources":      sources,
+		"destinations": targets,
+		"metrics":      []string{"duration", "distance"},
+		"units":        "m",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", apiKey)
+	header.Set("Content-Type", "application/json")
+	request := &httpclient.Request{
+		Method:      http.MethodPost,
+		URL:         OpenRouteServiceMatrixURL,
+		Header:      header,
+		Body:        body,
+		Idempotent:  true,
+		BeforeRetry: ORSUpstream().Wait,
+	}
+
+	respBody, err := ORSUpstream().Do(ctx, request.URL+"\n"+string(body), func(ctx context.Context) ([]byte, error) {
+		resp, err := httpclient.Default().Do(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Body: resp.Body}
+		}
+		return resp.Body, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matrix orsMatrixResponse
+	if err := json.Unmarshal(respBody, &matrix); err != nil {
+		return nil, fmt.Errorf("failed to parse upstream response: %w", err)
+	}
+	if len(matrix.Durations) != len(origins) || len(matrix.Distances) != len(origins) {
+		return nil, fmt.Errorf("unexpected matrix size")
+	}
+	for i := range origins {
+		if len(matrix.Durations[i]) != len(destinations) || len(matrix.Distances[i]) != len(destinations) {
+			return nil, fmt.Errorf("unexpected matrix size")
+		}
+	}
+	return &matrix, nil
+}
+
+// countHazardsNearSegment は線分 a-b から corridorMeters 以内にある違反率の交差点と注意点 (取締強化交差点・事故多発地点・承認済みの報告) を数える
+func countHazardsNearSegment(a, b []float64, corridorMeters float64) int {
+	segment := [][]float64{a, b}
+	return len(ViolationRateIndex().AlongPolyline(segment, corridorMeters)) +
+		len(WarningPointIndex().AlongPolyline(segment, corridorMeters))
+}
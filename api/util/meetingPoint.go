@@ -0,0 +1,266 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	minMeetingRiders = 2
+	maxMeetingRiders = 10
+	// 行列計算に使う候補地の数
+	maxMeetingCandidates = 10
+	// 候補地を探す範囲 (全員の重心から最も遠いライダーまでの距離 + 余白, 上限あり)
+	meetingSearchMarginMeters = 500.0
+	maxMeetingSearchMeters    = 5000.0
+)
+
+// 集合場所の候補にするカテゴリ
+var defaultMeetingCategories = []string{"bicycle_parking", "park"}
+
+// MeetingPointRequest は /meeting_point のリクエスト
+type MeetingPointRequest struct {
+	Riders      [][]float64 `json:"riders" binding:"required"`                     // 各ライダーの出発地 [[経度, 緯度], ...] (2-10人)
+	Destination []float64   `json:"destination,omitempty"`                         // 集合後の目的地 [経度, 緯度]
+	Objective   string      `json:"objective,omitempty" example:"max"`             // max: 最も遅いライダーの所要時間を最小化, total: 全員の合計を最小化
+	Categories  []string    `json:"categories,omitempty" example:"park"`           // 候補地のカテゴリ (デフォルト: bicycle_parking, park)
+	Profile     string      `json:"profile,omitempty" example:"child_with_parent"` // ライダープロファイル
+	DepartAt    string      `json:"depart_at,omitempty"`                           // 出発日時 (RFC3339 または 2006-01-02T15:04 の日本時間、省略時は現在)。この時点で有効な通行規制を避ける
+}
+
+// MeetingPointCandidate は集合場所の候補と評価値
+type MeetingPointCandidate struct {
+	ID                  string    `json:"id,omitempty"`
+	Name                string    `json:"name"`
+	Category            string    `json:"category"`
+	Coordinate          []float64 `json:"coordinate"`
+	Score               float64   `json:"score"`                          // 目的関数の値(秒, 危険箇所のペナルティ込み)
+	MaxDuration         float64   `json:"max_duration"`                   // 最も遅いライダーの所要時間(秒)
+	TotalDuration       float64   `json:"total_duration"`                 // 全ライダーの所要時間の合計(秒)
+	HazardExposure      int       `json:"hazard_exposure"`                // 全ライダーの経路付近の危険箇所の合計
+	DestinationDuration float64   `json:"destination_duration,omitempty"` // 集合場所から目的地までの所要時間(秒)
+}
+
+// MeetingPointResponse は選ばれた集合場所と各ライダーのルート
+type MeetingPointResponse struct {
+	MeetingPoint     MeetingPointCandidate   `json:"meeting_point"`
+	Alternatives     []MeetingPointCandidate `json:"alternatives"`
+	Routes           []DirectionsResponse    `json:"routes"`                      // riders と同じ順の、各ライダーから集合場所までのルート
+	DestinationRoute *DirectionsResponse     `json:"destination_route,omitempty"` // 集合場所から目的地までのルート
+}
+
+// GetMeetingPoint godoc
+// @Summary グループライドの集合場所検索
+// @Description 2-10人の出発地から、駐輪場や公園などの候補地のうち所要時間と危険箇所のペナルティが最小になる集合場所を選び、各ライダーのルートを返す
+// @Tags map
+// @Accept json
+// @Produce json
+// @Param request body MeetingPointRequest true "ライダーの出発地と目的地"
+// @Param Accept-Language header string false "注意点のメッセージの言語 (ja, en。デフォルト ja)"
+// @Success 200 {object} MeetingPointResponse "集合場所と各ライダーのルート"
+// @Failure 400 {object} ErrorResponse "リクエストパラメータ不正"
+// @Failure 429 {object} ORSErrorResponse "OpenRouteServiceのリクエスト予算超過"
+// @Failure 502 {object} ORSErrorResponse "ルート取得失敗"
+// @Router /meeting_point [post]
+func GetMeetingPoint(c *gin.Context) {
+	var request MeetingPointRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if len(request.Riders) < minMeetingRiders {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid riders", Message: fmt.Sprintf("at least %d riders are required", minMeetingRiders)})
+		return
+	}
+	riders, err := toCoordinates(request.Riders, maxMeetingRiders)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid riders", Message: err.Error()})
+		return
+	}
+	var destination []Coordinate
+	if request.Destination != nil {
+		destination, err = toCoordinates([][]float64{request.Destination}, 1)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid destination", Message: err.Error()})
+			return
+		}
+	}
+	objective := request.Objective
+	if objective == "" {
+		objective = "max"
+	}
+	if objective != "max" && objective != "total" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid objective", Message: "objective must be max or total"})
+		return
+	}
+	categories := request.Categories
+	if len(categories) == 0 {
+		categories = defaultMeetingCategories
+	}
+
+	speedKmh := matrixDefaultSpeedKmh
+	var routeOptions *ORSRouteOptions
+	if request.Profile != "" {
+		profile, ok := LookupRiderProfile(request.Profile)
+		if !ok {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid profile", Message: fmt.Sprintf("unknown profile: %s", request.Profile)})
+			return
+		}
+		if profile.SpeedKmh > 0 {
+			speedKmh = profile.SpeedKmh
+		}
+		routeOptions = profile.RouteOptions()
+	}
+	departAt, err := ParseDepartAt(request.DepartAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid depart_at", Message: err.Error()})
+		return
+	}
+
+	candidates := meetingCandidates(riders, categories)
+	points := make([]Coordinate, len(candidates))
+	for i, candidate := range candidates {
+		points[i] = Coordinate{candidate.Coordinate[0], candidate.Coordinate[1]}
+	}
+
+	ctx := c.Request.Context()
+	toCandidates, err := ComputeBicycleMatrix(ctx, riders, points, speedKmh)
+	if err != nil {
+		respondMatrixError(c, err)
+		return
+	}
+	var toDestination MatrixResponse
+	if len(destination) > 0 {
+		toDestination, err = ComputeBicycleMatrix(ctx, points, destination, speedKmh)
+		if err != nil {
+			respondMatrixError(c, err)
+			return
+		}
+	}
+
+	for j := range candidates {
+		for i := range riders {
+			weighted := toCandidates.WeightedDurations[i][j]
+			candidates[j].MaxDuration = math.Max(candidates[j].MaxDuration, toCandidates.Durations[i][j])
+			candidates[j].TotalDuration += toCandidates.Durations[i][j]
+			candidates[j].HazardExposure += toCandidates.HazardExposure[i][j]
+			if objective == "max" {
+				candidates[j].Score = math.Max(candidates[j].Score, weighted)
+			} else {
+				candidates[j].Score += weighted
+			}
+		}
+		if len(destination) > 0 {
+			candidates[j].DestinationDuration = toDestination.Durations[j][0]
+			// 集合後は全員で目的地へ向かう
+			if objective == "max" {
+				candidates[j].Score += toDestination.WeightedDurations[j][0]
+			} else {
+				candidates[j].Score += toDestination.WeightedDurations[j][0] * float64(len(riders))
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Score < candidates[b].Score })
+
+	response := MeetingPointResponse{
+		MeetingPoint: candidates[0],
+		Alternatives: candidates[1:],
+	}
+	meetingPoint := FormatCoordinate(Coordinate{candidates[0].Coordinate[0], candidates[0].Coordinate[1]})
+	meetingCoordinate := Coordinate{candidates[0].Coordinate[0], candidates[0].Coordinate[1]}
+	for _, rider := range riders {
+		options, avoidedRestrictions := withActiveRestrictions(routeOptions, []Coordinate{rider, meetingCoordinate}, departAt)
+		status, orsResp := GetDirectionsBaseWithOptions(ctx, FormatCoordinate(rider), meetingPoint, options)
+		route, ok := orsResp.(DirectionsResponse)
+		if !ok {
+			if status == http.StatusTooManyRequests {
+				c.Header("Retry-After", RetryAfterSeconds(ORSUpstream()))
+			}
+			c.JSON(status, orsResp)
+			return
+		}
+		route.AvoidedRestrictions = avoidedRestrictions
+		route.WarningPoints = RouteWarningPoints(route.Features[0], MessageLanguage(c))
+		route.SessoinID = GenerateSessionID()
+		SaveSessionGeometry(route.SessoinID, route.Features[0].Geometry)
+		response.Routes = append(response.Routes, route)
+	}
+	if len(destination) > 0 {
+		// 全員が揃う時刻に集合場所を出発する
+		arrival := departAt.Add(time.Duration(candidates[0].MaxDuration) * time.Second)
+		options, avoidedRestrictions := withActiveRestrictions(routeOptions, []Coordinate{meetingCoordinate, destination[0]}, arrival)
+		status, orsResp := GetDirectionsBaseWithOptions(ctx, meetingPoint, FormatCoordinate(destination[0]), options)
+		route, ok := orsResp.(DirectionsResponse)
+		if !ok {
+			if status == http.StatusTooManyRequests {
+				c.Header("Retry-After", RetryAfterSeconds(ORSUpstream()))
+			}
+			c.JSON(status, orsResp)
+			return
+		}
+		route.AvoidedRestrictions = avoidedRestrictions
+		route.WarningPoints = RouteWarningPoints(route.Features[0], MessageLanguage(c))
+		route.SessoinID = GenerateSessionID()
+		SaveSessionGeometry(route.SessoinID, route.Features[0].Geometry)
+		response.DestinationRoute = &route
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// meetingCandidates は全ライダーの重心付近にある候補地を重心に近い順に返す
+// 候補地が無い場合は重心そのものを候補とする
+func meetingCandidates(riders []Coordinate, categories []string) []MeetingPointCandidate {
+	centroid := []float64{0, 0}
+	for _, r := range riders {
+		centroid[0] += r[0] / float64(len(riders))
+		centroid[1] += r[1] / float64(len(riders))
+	}
+	radius := 0.0
+	for _, r := range riders {
+		radius = math.Max(radius, haversineMeters(centroid, r[:]))
+	}
+	radius = math.Min(radius+meetingSearchMarginMeters, maxMeetingSearchMeters)
+
+	type scored struct {
+		poi      POI
+		distance float64
+	}
+	var nearby []scored
+	for _, p := range POIsByCategory(categories...) {
+		if d := haversineMeters(centroid, p.Coordinate); d <= radius {
+			nearby = append(nearby, scored{p, d})
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool { return nearby[i].distance < nearby[j].distance })
+
+	var candidates []MeetingPointCandidate
+	for _, n := range nearby {
+		if len(candidates) >= maxMeetingCandidates {
+			break
+		}
+		name := n.poi.Name
+		if name == "" {
+			name = n.poi.Category
+		}
+		candidates = append(candidates, MeetingPointCandidate{
+			ID:         n.poi.ID,
+			Name:       name,
+			Category:   n.poi.Category,
+			Coordinate: n.poi.Coordinate,
+		})
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, MeetingPointCandidate{
+			Name:       "中間地点",
+			Category:   "centroid",
+			Coordinate: centroid,
+		})
+	}
+	return candidates
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"fmt"
+)
+
+// POI は prepare-data/prepare_poi で OpenStreetMap から作成した地点
+type POI struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Category   string            `json:"category"`   // bicycle_parking, park, convenience など
+	Coordinate []float64         `json:"coordinate"` // [経度, 緯度]
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// pois は地点データ(POIS_FILE, デフォルト data/pois.json)
+// ファイルが無い場合は空として扱う
+var pois = newDataset("pois", "POIS_FILE", "data/pois.json", parseJSONDataset(func(p POI) error {
+	if p.Category == "" {
+		return fmt.Errorf("poi %s has no category", p.ID)
+	}
+	return validateCoordinate(p.Coordinate)
+}))
+
+// POIs は読み込み済みの地点データを返す
+func POIs() []POI {
+	return pois.Get()
+}
+
+// POIsByCategory は指定したカテゴリの地点を返す (指定が無ければ全て)
+func POIsByCategory(categories ...string) []POI {
+	all := POIs()
+	if len(categories) == 0 {
+		return all
+	}
+	wanted := map[string]bool{}
+	for _, c := range categories {
+		wanted[c] = true
+	}
+	var result []POI
+	for _, p := range all {
+		if wanted[p.Category] && len(p.Coordinate) == 2 {
+			result = append(result, p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,178 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RiderProfile は利用者の属性ごとにまとめたルート検索の設定
+//
+// 回避フラグの既定値、ORSに渡す weightings / restrictions、想定速度、快適度スコアの重みを持つ。
+// restrictions は cycling 系プロファイルでは gradient のみ受け付けるORSが多いため、
+// minimum_width などは対応したバックエンドを使う場合に設定ファイルで指定する。
+type RiderProfile struct {
+	Name               string           `json:"name" example:"cargo_bike"`
+	Label              string           `json:"label" example:"カーゴバイク"`
+	AvoidBusStops      bool             `json:"avoid_bus_stops"`
+	AvoidTrafficLights bool             `json:"avoid_traffic_lights"`
+	ViaBikeParking     bool             `json:"via_bike_parking"`
+	AvoidFeatures      []string         `json:"avoid_features,omitempty"`
+	Weightings         *ORSWeightings   `json:"weightings,omitempty"`
+	Restrictions       *ORSRestrictions `json:"restrictions,omitempty"`
+	SpeedKmh           float64          `json:"speed_kmh" example:"12"` // 想定する平均速度
+	ComfortWeights     ComfortWeights   `json:"comfort_weights"`
+}
+
+// ComfortWeights は快適度スコア(0-100)の配点
+type ComfortWeights struct {
+	Base               int `json:"base"`
+	AvoidBusStops      int `json:"avoid_bus_stops"`
+	AvoidTrafficLights int `json:"avoid_traffic_lights"`
+	ViaBikeParking     int `json:"via_bike_parking"`
+}
+
+// RouteOptions はORSに渡すプロファイル由来のオプション
+func (p RiderProfile) RouteOptions() *ORSRouteOptions {
+	if len(p.AvoidFeatures) == 0 && p.Weightings == nil && p.Restrictions == nil {
+		return nil
+	}
+	options := &ORSRouteOptions{AvoidFeatures: p.AvoidFeatures}
+	if p.Weightings != nil || p.Restrictions != nil {
+		options.ProfileParams = &ORSProfileParams{
+			Weightings:   p.Weightings,
+			Restrictions: p.Restrictions,
+		}
+	}
+	return options
+}
+
+// ComfortScore は有効な回避フラグから快適度スコアを計算する
+func (p RiderProfile) ComfortScore(viaBikeParking, avoidBusStops, avoidTrafficLights bool) int {
+	score := p.ComfortWeights.Base
+	if viaBikeParking {
+		score += p.ComfortWeights.ViaBikeParking
+	}
+	if avoidBusStops {
+		score += p.ComfortWeights.AvoidBusStops
+	}
+	if avoidTrafficLights {
+		score += p.ComfortWeights.AvoidTrafficLights
+	}
+	return max(0, min(100, score))
+}
+
+// EstimatedDuration は想定速度での所要時間(秒)
+func (p RiderProfile) EstimatedDuration(distanceMeters float64) float64 {
+	if p.SpeedKmh <= 0 {
+		return 0
+	}
+	return distanceMeters / (p.SpeedKmh * 1000 / 3600)
+}
+
+func intPtr(v int) *int             { return &v }
+func float32Ptr(v float32) *float32 { return &v }
+
+// defaultRiderProfiles は設定ファイルが無い場合のプロファイル
+var defaultRiderProfiles = []RiderProfile{
+	{
+		Name:               "beginner",
+		Label:              "初心者",
+		AvoidBusStops:      true,
+		AvoidTrafficLights: true,
+		ViaBikeParking:     true,
+		Weightings:         &ORSWeightings{SteepnessDifficulty: intPtr(0), Quiet: float32Ptr(1)},
+		SpeedKmh:           10,
+		ComfortWeights:     ComfortWeights{Base: 40, AvoidBusStops: 25, AvoidTrafficLights: 20, ViaBikeParking: 15},
+	},
+	{
+		Name:               "child_with_parent",
+		Label:              "子ども連れ",
+		AvoidBusStops:      true,
+		AvoidTrafficLights: true,
+		AvoidFeatures:      []string{"steps"},
+		Weightings:         &ORSWeightings{SteepnessDifficulty: intPtr(0), Quiet: float32Ptr(1), Green: float32Ptr(0.5)},
+		Restrictions:       &ORSRestrictions{Gradient: intPtr(5)},
+		SpeedKmh:           8,
+		ComfortWeights:     ComfortWeights{Base: 30, AvoidBusStops: 35, AvoidTrafficLights: 25, ViaBikeParking: 10},
+	},
+	{
+		Name:           "commuter",
+		Label:          "通勤",
+		Weightings:     &ORSWeightings{SteepnessDifficulty: intPtr(1)},
+		SpeedKmh:       16,
+		ComfortWeights: ComfortWeights{Base: 60, AvoidBusStops: 20, AvoidTrafficLights: 15, ViaBikeParking: 5},
+	},
+	{
+		Name:           "cargo_bike",
+		Label:          "カーゴバイク",
+		AvoidBusStops:  true,
+		ViaBikeParking: true,
+		AvoidFeatures:  []string{"steps"},
+		Weightings:     &ORSWeightings{SteepnessDifficulty: intPtr(0)},
+		Restrictions:   &ORSRestrictions{Gradient: intPtr(6)},
+		SpeedKmh:       12,
+		ComfortWeights: ComfortWeights{Base: 45, AvoidBusStops: 25, AvoidTrafficLights: 10, ViaBikeParking: 20},
+	},
+	{
+		Name:           "ebike",
+		Label:          "電動アシスト",
+		Weightings:     &ORSWeightings{SteepnessDifficulty: intPtr(3)},
+		SpeedKmh:       18,
+		ComfortWeights: ComfortWeights{Base: 55, AvoidBusStops: 20, AvoidTrafficLights: 15, ViaBikeParking: 10},
+	},
+}
+
+// configuredRiderProfiles は既定のプロファイルを上書き・追加する設定ファイル (RIDER_PROFILES_FILE, デフォルト data/rider_profiles.json)
+var configuredRiderProfiles = newDataset("rider_profiles", "RIDER_PROFILES_FILE", "data/rider_profiles.json", parseJSONDataset(func(p RiderProfile) error {
+	if p.Name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if p.SpeedKmh < 0 {
+		return fmt.Errorf("profile %s: speed_kmh must not be negative", p.Name)
+	}
+	return nil
+}))
+
+// riderProfiles は既定のプロファイルに設定ファイルの内容を上書き・追加したもの
+var riderProfiles = newDerived(func() map[string]RiderProfile {
+	profiles := map[string]RiderProfile{}
+	for _, p := range defaultRiderProfiles {
+		profiles[p.Name] = p
+	}
+	for _, p := range configuredRiderProfiles.Get() {
+		profiles[p.Name] = p
+	}
+	return profiles
+})
+
+// LookupRiderProfile は名前からプロファイルを取得する
+func LookupRiderProfile(name string) (RiderProfile, bool) {
+	p, ok := riderProfiles.get()[name]
+	return p, ok
+}
+
+// RiderProfiles は全プロファイルを名前順に返す
+func RiderProfiles() []RiderProfile {
+	all := riderProfiles.get()
+	profiles := make([]RiderProfile, 0, len(all))
+	for _, p := range all {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// GetRiderProfiles godoc
+// @Summary ライダープロファイル一覧
+// @Description /directions/bicycle の profile パラメータで指定できるプロファイルを返す
+// @Tags map
+// @Accept json
+// @Produce json
+// @Success 200 {object} []RiderProfile "プロファイル一覧"
+// @Router /profiles [get]
+func GetRiderProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, RiderProfiles())
+}
@@ -0,0 +1,647 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 報告の審査状態
+const (
+	ReportPending  = "pending"  // 未審査 (注意点には出さない)
+	ReportApproved = "approved" // 承認済み (注意点・経路の注意点に出す)
+	ReportRejected = "rejected" // 却下
+)
+
+// 承認済みの報告の注意点の種類
+const ReportWarningPointType = "user_report"
+
+const (
+	maxReportDescription = 500  // 説明の最大文字数
+	maxReportPhotoURL    = 1000 // 写真の URL の最大長
+)
+
+// reportType は報告の種類ごとの表示名と、報告・確認されてから有効な期間
+type reportType struct {
+	Label string
+	TTL   time.Duration
+}
+
+var reportTypes = map[string]reportType{
+	"pothole":         {Label: "路面の穴・段差", TTL: 30 * 24 * time.Hour},
+	"illegal_parking": {Label: "路上駐車", TTL: 3 * time.Hour},
+	"construction":    {Label: "工事", TTL: 14 * 24 * time.Hour},
+	"dangerous_merge": {Label: "危険な合流", TTL: 90 * 24 * time.Hour},
+	"other":           {Label: "その他の危険", TTL: 7 * 24 * time.Hour},
+}
+
+// HazardReport は利用者から報告された危険箇所
+type HazardReport struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type" example:"pothole"` // pothole, illegal_parking, construction, dangerous_merge, other
+	Coordinate     []float64 `json:"coordinate"`             // [経度, 緯度]
+	Description    string    `json:"description,omitempty"`
+	PhotoURL       string    `json:"photo_url,omitempty"`
+	Status         string    `json:"status" example:"pending"`  // pending, approved, rejected
+	ModerationNote string    `json:"moderation_note,omitempty"` // 審査のメモ (管理者のみ)
+	Confirmations  int       `json:"confirmations"`             // 同じ場所の同じ種類の報告の数 (最初の報告を含む)
+	Upvotes        int       `json:"upvotes"`
+	Downvotes      int       `json:"downvotes"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	ExpiresAt      time.Time `json:"expires_at"` // これを過ぎると表示せず、次の保存で削除する
+}
+
+// storedReport は保存する報告 (報告者・投票者はハッシュにして重複の判定だけに使う)
+type storedReport struct {
+	HazardReport
+	Reporters []string          `json:"reporters,omitempty"`
+	Voters    map[string]string `json:"voters,omitempty"` // 投票者ごとの票 (up, down)
+
+	// 接続元ごとの報告・票 (自動承認と票による期限切れはこちらで数える)
+	// reporter_id・voter_id は利用者が自由に送れるので、ID を変えて何度も送っても1件にする
+	Sources     []string          `json:"sources,omitempty"`
+	SourceVotes map[string]string `json:"source_votes,omitempty"`
+}
+
+// CreateReportRequest は危険箇所の報告
+type CreateReportRequest struct {
+	Type        string    `json:"type" binding:"required" example:"pothole"`
+	Coordinate  []float64 `json:"coordinate" binding:"required"` // [経度, 緯度]
+	Description string    `json:"description,omitempty" example:"車道の左端に大きな穴"`
+	PhotoURL    string    `json:"photo_url,omitempty"`   // アップロード済みの写真の URL
+	ReporterID  string    `json:"reporter_id,omitempty"` // 端末ごとの識別子 (省略時は接続元)
+}
+
+// CreateReportResponse は報告の結果
+type CreateReportResponse struct {
+	Report    HazardReport `json:"report"`
+	Duplicate bool         `json:"duplicate"` // 既存の報告にまとめた場合 true
+}
+
+// ReportVoteRequest は報告への投票
+type ReportVoteRequest struct {
+	Vote    string `json:"vote" binding:"required" example:"up"` // up: まだある, down: もう無い・誤り
+	VoterID string `json:"voter_id,omitempty"`                   // 端末ごとの識別子 (省略時は接続元)
+}
+
+// ReportModerationRequest は報告の審査
+type ReportModerationRequest struct {
+	Status string `json:"status" binding:"required" example:"approved"` // pending, approved, rejected
+	Note   string `json:"note,omitempty"`
+}
+
+// PostReport godoc
+// @Summary 危険箇所の報告
+// @Description 路面の穴・路上駐車・工事・危険な合流などを報告する。同じ種類の未却下の報告が REPORT_DUPLICATE_DISTANCE (m, デフォルト30) 以内にある場合はその報告にまとめ、有効期限を延ばす。報告は審査 (または REPORT_AUTO_APPROVE_CONFIRMATIONS を設定した場合はその数の異なる接続元からの報告) で承認されると /warning_point と経路の注意点に出る
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Param request body CreateReportRequest true "報告"
+// @Success 201 {object} CreateReportResponse "新しい報告"
+// @Success 200 {object} CreateReportResponse "既存の報告にまとめた"
+// @Failure 400 {object} ErrorResponse "リクエスト不正"
+// @Failure 500 {object} ErrorResponse "保存に失敗"
+// @Router /reports [post]
+func PostReport(c *gin.Context) {
+	var request CreateReportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := validateReport(request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid report", Message: err.Error()})
+		return
+	}
+	report, duplicate, err := hazardReports().add(request, clientKey(c, request.ReporterID), sourceKey(c), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save report", Message: err.Error()})
+		return
+	}
+	status := http.StatusCreated
+	if duplicate {
+		status = http.StatusOK
+	}
+	c.JSON(status, CreateReportResponse{Report: publicReport(report, false), Duplicate: duplicate})
+}
+
+// GetReports godoc
+// @Summary 危険箇所の報告の一覧
+// @Description 有効期限内の報告を新しい順に返す。approved 以外の status は管理者 (Authorization: Bearer ADMIN_TOKEN) のみ
+// @Tags reports
+// @Produce json
+// @Param status query string false "pending, approved (デフォルト), rejected"
+// @Param type query string false "報告の種類 (カンマ区切り)"
+// @Success 200 {object} []HazardReport
+// @Failure 400 {object} ErrorResponse "パラメータ不正"
+// @Failure 401 {object} ErrorResponse "管理者以外が approved 以外を指定"
+// @Router /reports [get]
+func GetReports(c *gin.Context) {
+	status := c.DefaultQuery("status", ReportApproved)
+	if !validReportStatus(status) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid parameter", Message: "status must be pending, approved or rejected"})
+		return
+	}
+	admin := isAdmin(c)
+	if status != ReportApproved && !admin {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized", Message: "only approved reports are public"})
+		return
+	}
+	types := splitList(c.Query("type"))
+	result := []HazardReport{}
+	for _, r := range hazardReports().list(time.Now()) {
+		if r.Status != status || (len(types) > 0 && !slices.Contains(types, r.Type)) {
+			continue
+		}
+		result = append(result, publicReport(r, admin))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetReport godoc
+// @Summary 危険箇所の報告
+// @Description 報告の審査状態・投票数・有効期限を返す
+// @Tags reports
+// @Produce json
+// @Param id path string true "報告の id"
+// @Success 200 {object} HazardReport
+// @Failure 404 {object} ErrorResponse "報告が無い・期限切れ"
+// @Router /reports/{id} [get]
+func GetReport(c *gin.Context) {
+	report, ok := hazardReports().get(c.Param("id"), time.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Report not found", Message: c.Param("id")})
+		return
+	}
+	c.JSON(http.StatusOK, publicReport(report, isAdmin(c)))
+}
+
+// PostReportVote godoc
+// @Summary 危険箇所の報告への投票
+// @Description up (まだある) は有効期限を延ばし、down (もう無い・誤り) が up より REPORT_DOWNVOTE_MARGIN (デフォルト3) 票以上多くなると報告を期限切れにする。同じ人の票は最後のものだけ数え、期限切れの判定は接続元ごとの最後の票で行う
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Param id path string true "報告の id"
+// @Param request body ReportVoteRequest true "投票"
+// @Success 200 {object} HazardReport
+// @Failure 400 {object} ErrorResponse "リクエスト不正"
+// @Failure 404 {object} ErrorResponse "報告が無い・期限切れ"
+// @Failure 500 {object} ErrorResponse "保存に失敗"
+// @Router /reports/{id}/votes [post]
+func PostReportVote(c *gin.Context) {
+	var request ReportVoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if request.Vote != "up" && request.Vote != "down" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid vote", Message: "vote must be up or down"})
+		return
+	}
+	report, err := hazardReports().vote(c.Param("id"), clientKey(c, request.VoterID), sourceKey(c), request.Vote, time.Now())
+	if err != nil {
+		writeReportError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, publicReport(report, false))
+}
+
+// PutReportModeration godoc
+// @Summary 危険箇所の報告の審査
+// @Description 報告を承認・却下する (管理者のみ、Authorization: Bearer ADMIN_TOKEN)
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Param id path string true "報告の id"
+// @Param request body ReportModerationRequest true "審査"
+// @Success 200 {object} HazardReport
+// @Failure 400 {object} ErrorResponse "リクエスト不正"
+// @Failure 401 {object} ErrorResponse "管理者でない"
+// @Failure 404 {object} ErrorResponse "報告が無い・期限切れ"
+// @Failure 500 {object} ErrorResponse "保存に失敗"
+// @Router /reports/{id}/moderation [put]
+func PutReportModeration(c *gin.Context) {
+	var request ReportModerationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if !validReportStatus(request.Status) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid status", Message: "status must be pending, approved or rejected"})
+		return
+	}
+	report, err := hazardReports().moderate(c.Param("id"), request.Status, request.Note, time.Now())
+	if err != nil {
+		writeReportError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, publicReport(report, true))
+}
+
+// errReportNotFound は報告が無い・期限切れの場合のエラー
+var errReportNotFound = fmt.Errorf("report not found")
+
+func writeReportError(c *gin.Context, err error) {
+	if err == errReportNotFound {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Report not found", Message: c.Param("id")})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save report", Message: err.Error()})
+}
+
+func validReportStatus(status string) bool {
+	return status == ReportPending || status == ReportApproved || status == ReportRejected
+}
+
+// validateReport は報告の内容を確かめる
+func validateReport(request CreateReportRequest) error {
+	if _, ok := reportTypes[request.Type]; !ok {
+		return fmt.Errorf("unknown type: %s", request.Type)
+	}
+	if _, err := toCoordinates([][]float64{request.Coordinate}, 1); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(request.Description) > maxReportDescription {
+		return fmt.Errorf("description must be at most %d characters", maxReportDescription)
+	}
+	if request.PhotoURL != "" {
+		u, err := url.Parse(request.PhotoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(request.PhotoURL) > maxReportPhotoURL {
+			return fmt.Errorf("photo_url must be an http(s) URL")
+		}
+	}
+	return nil
+}
+
+// clientKey は報告者・投票者の識別子 (省略時は接続元) をハッシュにする
+// 重複の判定にだけ使い、元の値は保存しない
+func clientKey(c *gin.Context, id string) string {
+	if id == "" {
+		return sourceKey(c)
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// sourceKey は接続元 (TRUSTED_PROXIES 経由の場合は X-Forwarded-For の接続元) をハッシュにする
+func sourceKey(c *gin.Context) string {
+	sum := sha256.Sum256([]byte("ip:" + c.ClientIP()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// publicReport は返す報告 (審査のメモは管理者にだけ返す)
+func publicReport(r HazardReport, admin bool) HazardReport {
+	if !admin {
+		r.ModerationNote = ""
+	}
+	return r
+}
+
+// WarningPoint は承認済みの報告を注意点にする
+func (r HazardReport) WarningPoint() WarningPoint {
+	report := publicReport(r, false)
+	name, message := reportWarningPointMessage(hazardMessages.get(), defaultMessageLanguage, report)
+	return WarningPoint{
+		Type:       ReportWarningPointType,
+		Name:       name,
+		Coordinate: r.Coordinate,
+		Message:    message,
+		Report:     &report,
+	}
+}
+
+// reportWarningPointMessage は報告の種類の表示名とメッセージを返す
+// 説明があればそのまま使い、無ければ user_report のテンプレートから作る
+func reportWarningPointMessage(catalog *hazardMessageCatalog, lang string, r HazardReport) (name, message string) {
+	name = catalog.label(lang, r.Type)
+	if name == "" {
+		name = reportTypes[r.Type].Label
+	}
+	if r.Description != "" {
+		return name, r.Description
+	}
+	return name, catalog.message(lang, ReportWarningPointType, "", r.ID, map[string]string{"label": name})
+}
+
+// reportStore は報告を REPORTS_FILE (デフォルト data/reports.json) に保存する
+type reportStore struct {
+	mu      sync.Mutex
+	path    string
+	reports []*storedReport
+	version uint64 // 報告を変えるたびに増やす (注意点の索引の作り直しに使う)
+
+	// 取締強化交差点などに承認済みの報告を加えた注意点の索引
+	index           *SpatialIndex[WarningPoint]
+	indexBase       *SpatialIndex[WarningPoint]
+	indexVersion    uint64
+	indexValidUntil time.Time
+}
+
+var (
+	reportsOnce sync.Once
+	reports     *reportStore
+)
+
+// hazardReports は保存済みの報告を読み込んだストアを返す
+func hazardReports() *reportStore {
+	reportsOnce.Do(func() {
+		path := os.Getenv("REPORTS_FILE")
+		if path == "" {
+			path = "data/reports.json"
+		}
+		reports = &reportStore{path: path}
+		if err := reports.load(); err != nil {
+			fmt.Println("reports load error:", err)
+		}
+	})
+	return reports
+}
+
+func (s *reportStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var loaded []*storedReport
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, r := range loaded {
+		if now.Before(r.ExpiresAt) {
+			s.reports = append(s.reports, r)
+		}
+	}
+	return nil
+}
+
+// save は期限切れの報告を除いた items を書き出し、成功した場合だけ入れ替える (s.mu を持って呼ぶ)
+func (s *reportStore) save(items []*storedReport, now time.Time) error {
+	active := []*storedReport{}
+	for _, r := range items {
+		if now.Before(r.ExpiresAt) {
+			active = append(active, r)
+		}
+	}
+	data, err := json.MarshalIndent(active, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.reports = active
+	s.version++
+	return nil
+}
+
+// update は old を updated に置き換えて (old が nil なら updated を加えて) 書き出す (s.mu を持って呼ぶ)
+// 書き出しに失敗した場合は元の報告のまま
+func (s *reportStore) update(old, updated *storedReport, now time.Time) error {
+	items := slices.Clone(s.reports)
+	if i := slices.Index(items, old); i >= 0 {
+		items[i] = updated
+	} else {
+		items = append(items, updated)
+	}
+	return s.save(items, now)
+}
+
+// clone は書き出しに成功するまで元の報告を変えないための複製を返す
+func (r *storedReport) clone() *storedReport {
+	c := *r
+	c.Reporters = slices.Clone(r.Reporters)
+	c.Voters = maps.Clone(r.Voters)
+	c.Sources = slices.Clone(r.Sources)
+	c.SourceVotes = maps.Clone(r.SourceVotes)
+	return &c
+}
+
+// find は有効期限内の報告を探す (s.mu を持って呼ぶ)
+func (s *reportStore) find(id string, now time.Time) *storedReport {
+	for _, r := range s.reports {
+		if r.ID == id && now.Before(r.ExpiresAt) {
+			return r
+		}
+	}
+	return nil
+}
+
+// add は報告を加える。同じ種類の却下されていない報告が近くにあればまとめ、true を返す
+// reporter は報告者の識別子、source は接続元 (自動承認の件数に使う)
+func (s *reportStore) add(request CreateReportRequest, reporter, source string, now time.Time) (HazardReport, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ttl := reportTypes[request.Type].TTL
+	duplicateMeters := float64(getEnvInt("REPORT_DUPLICATE_DISTANCE", 30))
+	var existing *storedReport
+	nearest := duplicateMeters
+	for _, r := range s.reports {
+		if r.Type != request.Type || r.Status == ReportRejected || !now.Before(r.ExpiresAt) {
+			continue
+		}
+		if d := haversineMeters(r.Coordinate, request.Coordinate); d <= nearest {
+			existing, nearest = r, d
+		}
+	}
+
+	if existing == nil {
+		r := &storedReport{
+			HazardReport: HazardReport{
+				ID:            uuid.New().String(),
+				Type:          request.Type,
+				Coordinate:    []float64{request.Coordinate[0], request.Coordinate[1]},
+				Description:   request.Description,
+				PhotoURL:      request.PhotoURL,
+				Status:        ReportPending,
+				Confirmations: 1,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+				ExpiresAt:     now.Add(ttl),
+			},
+			Reporters: []string{reporter},
+			Sources:   []string{source},
+		}
+		return r.HazardReport, false, s.update(nil, r, now)
+	}
+
+	// 同じ人の重複した報告は数えず、有効期限だけ延ばす
+	r := existing.clone()
+	if !slices.Contains(r.Reporters, reporter) {
+		r.Reporters = append(r.Reporters, reporter)
+		r.Confirmations++
+	}
+	if r.Description == "" {
+		r.Description = request.Description
+	}
+	if r.PhotoURL == "" {
+		r.PhotoURL = request.PhotoURL
+	}
+	if !slices.Contains(r.Sources, source) {
+		r.Sources = append(r.Sources, source)
+	}
+	// 自動承認 (REPORT_AUTO_APPROVE_CONFIRMATIONS, デフォルト0 は無効) は異なる接続元の数で判定する
+	if threshold := getEnvInt("REPORT_AUTO_APPROVE_CONFIRMATIONS", 0); threshold > 0 && r.Status == ReportPending && len(r.Sources) >= threshold {
+		r.Status = ReportApproved
+	}
+	r.extend(now, ttl)
+	return r.HazardReport, true, s.update(existing, r, now)
+}
+
+// vote は投票を反映する
+// 票数は voter ごとに数え、期限切れにするかは接続元 source ごとの最後の票で判定する
+func (s *reportStore) vote(id, voter, source, vote string, now time.Time) (HazardReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.find(id, now)
+	if current == nil {
+		return HazardReport{}, errReportNotFound
+	}
+	r := current.clone()
+	if r.Voters == nil {
+		r.Voters = map[string]string{}
+	}
+	switch r.Voters[voter] {
+	case "up":
+		r.Upvotes--
+	case "down":
+		r.Downvotes--
+	}
+	r.Voters[voter] = vote
+	if r.SourceVotes == nil {
+		r.SourceVotes = map[string]string{}
+	}
+	r.SourceVotes[source] = vote
+	if vote == "up" {
+		r.Upvotes++
+		r.extend(now, reportTypes[r.Type].TTL)
+	} else {
+		r.Downvotes++
+		r.UpdatedAt = now
+		up, down := 0, 0
+		for _, v := range r.SourceVotes {
+			if v == "up" {
+				up++
+			} else {
+				down++
+			}
+		}
+		if down-up >= getEnvInt("REPORT_DOWNVOTE_MARGIN", 3) {
+			r.ExpiresAt = now
+		}
+	}
+	return r.HazardReport, s.update(current, r, now)
+}
+
+// moderate は審査状態を変える
+func (s *reportStore) moderate(id, status, note string, now time.Time) (HazardReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.find(id, now)
+	if current == nil {
+		return HazardReport{}, errReportNotFound
+	}
+	r := current.clone()
+	r.Status = status
+	r.ModerationNote = note
+	r.UpdatedAt = now
+	return r.HazardReport, s.update(current, r, now)
+}
+
+// extend は報告・確認された時点から ttl の間は有効にする
+func (r *storedReport) extend(now time.Time, ttl time.Duration) {
+	r.UpdatedAt = now
+	if expires := now.Add(ttl); expires.After(r.ExpiresAt) {
+		r.ExpiresAt = expires
+	}
+}
+
+func (s *reportStore) get(id string, now time.Time) (HazardReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := s.find(id, now); r != nil {
+		return r.HazardReport, true
+	}
+	return HazardReport{}, false
+}
+
+// list は有効期限内の報告を新しい順に返す
+func (s *reportStore) list(now time.Time) []HazardReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []HazardReport
+	for _, r := range s.reports {
+		if now.Before(r.ExpiresAt) {
+			result = append(result, r.HazardReport)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result
+}
+
+// approvedWarningPoints は承認済みで有効期限内の報告の注意点と、そのうち最も早い有効期限を返す (s.mu を持って呼ぶ)
+func (s *reportStore) approvedWarningPoints(now time.Time) ([]WarningPoint, time.Time) {
+	var points []WarningPoint
+	var nextExpiry time.Time
+	for _, r := range s.reports {
+		if r.Status != ReportApproved || !now.Before(r.ExpiresAt) {
+			continue
+		}
+		points = append(points, r.WarningPoint())
+		if nextExpiry.IsZero() || r.ExpiresAt.Before(nextExpiry) {
+			nextExpiry = r.ExpiresAt
+		}
+	}
+	return points, nextExpiry
+}
+
+// ApprovedReportWarningPoints は承認済みの報告の注意点を返す
+func ApprovedReportWarningPoints() []WarningPoint {
+	s := hazardReports()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	points, _ := s.approvedWarningPoints(time.Now())
+	return points
+}
+
+// warningPointIndex は base (取締強化交差点・事故多発地点の索引) に承認済みの報告を加えた索引を返す
+// 報告か base が変わるか、含めた報告のどれかが期限切れになるまで同じ索引を使う
+func (s *reportStore) warningPointIndex(base *SpatialIndex[WarningPoint]) *SpatialIndex[WarningPoint] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.index != nil && s.indexBase == base && s.indexVersion == s.version && now.Before(s.indexValidUntil) {
+		return s.index
+	}
+	points, nextExpiry := s.approvedWarningPoints(now)
+	if len(points) == 0 {
+		s.index = base
+		// 承認された報告が無い間は報告が変わるまで作り直さない
+		nextExpiry = now.Add(24 * time.Hour)
+	} else {
+		s.index = NewSpatialIndex(slices.Concat(WarningPoints(), points), func(w WarningPoint) []float64 { return w.Coordinate })
+	}
+	s.indexBase, s.indexVersion, s.indexValidUntil = base, s.version, nextExpiry
+	return s.index
+}
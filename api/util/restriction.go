@@ -0,0 +1,652 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 規制の種類
+var restrictionTypes = []string{
+	"closure",         // 工事などによる通行止め
+	"pedestrian_zone", // 歩行者天国
+	"school_zone",     // スクールゾーン (時間帯で車両通行止め)
+	"event",           // 祭り・マラソンなどのイベント
+	"other",
+}
+
+// 曜日の表記 (time.Weekday の順)
+var restrictionDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// 規制の時刻は日本時間で扱う (夏時間が無いので固定の時差でよい)
+var restrictionLocation = time.FixedZone("JST", 9*60*60)
+
+const (
+	// LineString・Point の規制の幅の半分のデフォルト(m)
+	defaultRestrictionBufferMeters = 10.0
+	// 経路の出発地・到着地を囲む範囲に加える余白 (m, 出発地と到着地の距離に対する割合)
+	restrictionMinMarginMeters = 1000.0
+	restrictionMarginRatio     = 0.3
+)
+
+// Restriction は期間・時間帯のある通行規制
+type Restriction struct {
+	ID           string              `json:"id"`
+	Type         string              `json:"type" example:"pedestrian_zone"` // closure, pedestrian_zone, school_zone, event, other
+	Name         string              `json:"name,omitempty" example:"銀座 歩行者天国"`
+	Description  string              `json:"description,omitempty"`
+	Geometry     RestrictionGeometry `json:"geometry"`
+	BufferMeters float64             `json:"buffer_meters,omitempty"` // LineString・Point の幅の半分 (m, デフォルト10)
+	Windows      []RestrictionWindow `json:"windows,omitempty"`       // 有効な期間・時間帯 (いずれかに当てはまれば有効、無ければ常に有効)
+	Source       string              `json:"source,omitempty"`        // 出典
+	UpdatedAt    time.Time           `json:"updated_at"`
+
+	polygons [][][][]float64 // 回避範囲 (GeoJSON の MultiPolygon)
+	bbox     [4]float64      // 回避範囲を囲む範囲 (西, 南, 東, 北)
+}
+
+// RestrictionGeometry は GeoJSON の Polygon, MultiPolygon, LineString, Point
+type RestrictionGeometry struct {
+	Type        string          `json:"type" example:"Polygon"`
+	Coordinates json.RawMessage `json:"coordinates" swaggertype:"array,number"`
+}
+
+// RestrictionWindow は規制が有効な期間・時間帯
+//
+// start・end だけなら一回限り、days・start_time・end_time で毎週の繰り返しになる (start・end で繰り返す期間を区切れる)。
+// 時刻は日本時間で、end_time が start_time 以前の場合は翌日の end_time まで。
+type RestrictionWindow struct {
+	Start     *time.Time `json:"start,omitempty"`                      // この日時から有効
+	End       *time.Time `json:"end,omitempty"`                        // この日時まで有効 (含まない)
+	Days      []string   `json:"days,omitempty" example:"sat,sun"`     // 繰り返す曜日 (sun, mon, tue, wed, thu, fri, sat)
+	StartTime string     `json:"start_time,omitempty" example:"12:00"` // 繰り返す時間帯の開始 (HH:MM)
+	EndTime   string     `json:"end_time,omitempty" example:"17:00"`   // 繰り返す時間帯の終了 (HH:MM, 含まない)
+}
+
+// GeoJSON の FeatureCollection で取り込む場合の形 (properties に geometry 以外の項目を入れる)
+type restrictionFeatureCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Geometry   RestrictionGeometry `json:"geometry"`
+		Properties Restriction         `json:"properties"`
+	} `json:"features"`
+}
+
+// ImportRestrictionsResponse は規制の取り込み結果
+type ImportRestrictionsResponse struct {
+	Count int `json:"count"`
+}
+
+// GetRestrictions godoc
+// @Summary 通行規制の一覧
+// @Description 期間・時間帯のある通行規制 (通行止め・歩行者天国・スクールゾーンなど) を返す。at を指定するとその時点で有効なものだけを返す
+// @Tags restrictions
+// @Produce json
+// @Param at query string false "日時 (RFC3339 または 2006-01-02T15:04 の日本時間)" example(2025-06-01T14:00:00+09:00)
+// @Param type query string false "規制の種類 (カンマ区切り)"
+// @Success 200 {object} []Restriction
+// @Failure 400 {object} ErrorResponse "パラメータ不正"
+// @Router /restrictions [get]
+func GetRestrictions(c *gin.Context) {
+	var at *time.Time
+	if v := c.Query("at"); v != "" {
+		t, err := ParseDepartAt(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid parameter", Message: err.Error()})
+			return
+		}
+		at = &t
+	}
+	types := splitList(c.Query("type"))
+	result := []Restriction{}
+	for _, r := range restrictions().list() {
+		if len(types) > 0 && !slices.Contains(types, r.Type) {
+			continue
+		}
+		if at != nil && !r.ActiveAt(*at) {
+			continue
+		}
+		result = append(result, r)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// PostRestriction godoc
+// @Summary 通行規制の追加
+// @Description 通行規制を追加する (管理者のみ、Authorization: Bearer ADMIN_TOKEN)。id を省略すると割り当てる
+// @Tags restrictions
+// @Accept json
+// @Produce json
+// @Param request body Restriction true "通行規制"
+// @Success 201 {object} Restriction
+// @Failure 400 {object} ErrorResponse "リクエスト不正"
+// @Failure 401 {object} ErrorResponse "管理者でない"
+// @Failure 409 {object} ErrorResponse "同じ id の規制がある"
+// @Failure 500 {object} ErrorResponse "保存に失敗"
+// @Router /restrictions [post]
+func PostRestriction(c *gin.Context) {
+	var r Restriction
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	saved, err := restrictions().put(r, false)
+	if err != nil {
+		writeRestrictionError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, saved)
+}
+
+// PutRestriction godoc
+// @Summary 通行規制の更新
+// @Description 通行規制を置き換える (無ければ追加する、管理者のみ)
+// @Tags restrictions
+// @Accept json
+// @Produce json
+// @Param id path string true "規制の id"
+// @Param request body Restriction true "通行規制"
+// @Success 200 {object} Restriction
+// @Failure 400 {object} ErrorResponse "リクエスト不正"
+// @Failure 401 {object} ErrorResponse "管理者でない"
+// @Failure 500 {object} ErrorResponse "保存に失敗"
+// @Router /restrictions/{id} [put]
+func PutRestriction(c *gin.Context) {
+	var r Restriction
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	r.ID = c.Param("id")
+	saved, err := restrictions().put(r, true)
+	if err != nil {
+		writeRestrictionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteRestriction godoc
+// @Summary 通行規制の削除
+// @Description 通行規制を削除する (管理者のみ)
+// @Tags restrictions
+// @Param id path string true "規制の id"
+// @Success 204
+// @Failure 401 {object} ErrorResponse "管理者でない"
+// @Failure 404 {object} ErrorResponse "規制が無い"
+// @Failure 500 {object} ErrorResponse "保存に失敗"
+// @Router /restrictions/{id} [delete]
+func DeleteRestriction(c *gin.Context) {
+	if err := restrictions().delete(c.Param("id")); err != nil {
+		writeRestrictionError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ImportRestrictions godoc
+// @Summary 通行規制の取り込み
+// @Description 通行規制を全て置き換える (管理者のみ)。規制の配列または GeoJSON の FeatureCollection (properties に geometry 以外の項目) を受け付け、1件でも不正なら何も変えない
+// @Tags restrictions
+// @Accept json
+// @Produce json
+// @Param request body []Restriction true "通行規制の一覧"
+// @Success 200 {object} ImportRestrictionsResponse
+// @Failure 400 {object} ErrorResponse "リクエスト不正"
+// @Failure 401 {object} ErrorResponse "管理者でない"
+// @Failure 500 {object} ErrorResponse "保存に失敗"
+// @Router /restrictions [put]
+func ImportRestrictions(c *gin.Context) {
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	parsed, err := ParseRestrictions(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid restrictions", Message: err.Error()})
+		return
+	}
+	if err := restrictions().replace(parsed); err != nil {
+		writeRestrictionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ImportRestrictionsResponse{Count: len(parsed)})
+}
+
+// 規制のストアのエラー
+var (
+	errRestrictionNotFound = fmt.Errorf("restriction not found")
+	errRestrictionExists   = fmt.Errorf("restriction already exists")
+)
+
+// restrictionValidationError は規制の内容が不正な場合のエラー
+type restrictionValidationError struct{ err error }
+
+func (e restrictionValidationError) Error() string { return e.err.Error() }
+
+func writeRestrictionError(c *gin.Context, err error) {
+	switch err.(type) {
+	case restrictionValidationError:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid restriction", Message: err.Error()})
+		return
+	}
+	switch err {
+	case errRestrictionNotFound:
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Restriction not found", Message: c.Param("id")})
+	case errRestrictionExists:
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Restriction already exists", Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save restrictions", Message: err.Error()})
+	}
+}
+
+// ParseDepartAt は出発日時を読む。空の場合は現在時刻
+// RFC3339 の他、時差の無い "2006-01-02T15:04" は日本時間として扱う
+func ParseDepartAt(v string) (time.Time, error) {
+	if v == "" {
+		return time.Now(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, v, restrictionLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("depart_at must be RFC3339 or 2006-01-02T15:04 (JST): %q", v)
+}
+
+// ParseRestrictions は規制の配列または GeoJSON の FeatureCollection を読み、全ての規制を確かめる
+func ParseRestrictions(data []byte) ([]Restriction, error) {
+	var parsed []Restriction
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var collection restrictionFeatureCollection
+		if err := json.Unmarshal(trimmed, &collection); err != nil {
+			return nil, err
+		}
+		if collection.Type != "FeatureCollection" {
+			return nil, fmt.Errorf("expected an array of restrictions or a FeatureCollection")
+		}
+		for _, f := range collection.Features {
+			r := f.Properties
+			r.Geometry = f.Geometry
+			parsed = append(parsed, r)
+		}
+	} else if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i := range parsed {
+		if parsed[i].ID == "" {
+			parsed[i].ID = uuid.New().String()
+		}
+		if seen[parsed[i].ID] {
+			return nil, fmt.Errorf("duplicate id: %s", parsed[i].ID)
+		}
+		seen[parsed[i].ID] = true
+		if err := parsed[i].prepare(); err != nil {
+			return nil, fmt.Errorf("restriction %s: %w", parsed[i].ID, err)
+		}
+	}
+	return parsed, nil
+}
+
+// prepare は規制の内容を確かめ、回避範囲を作る
+func (r *Restriction) prepare() error {
+	if !slices.Contains(restrictionTypes, r.Type) {
+		return fmt.Errorf("type must be one of %s", strings.Join(restrictionTypes, ", "))
+	}
+	if r.BufferMeters < 0 {
+		return fmt.Errorf("buffer_meters must not be negative")
+	}
+	for i, w := range r.Windows {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("windows[%d]: %w", i, err)
+		}
+	}
+	buffer := r.BufferMeters
+	if buffer == 0 {
+		buffer = defaultRestrictionBufferMeters
+	}
+	polygons, err := r.Geometry.polygons(buffer)
+	if err != nil {
+		return fmt.Errorf("geometry: %w", err)
+	}
+	r.polygons = polygons
+	r.bbox = [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, polygon := range polygons {
+		for _, p := range polygon[0] {
+			r.bbox = [4]float64{min(r.bbox[0], p[0]), min(r.bbox[1], p[1]), max(r.bbox[2], p[0]), max(r.bbox[3], p[1])}
+		}
+	}
+	return nil
+}
+
+// polygons は geometry を回避範囲 (MultiPolygon の座標) にする
+// LineString は線分ごとに幅 buffer×2 の四角形、Point は一辺 buffer×2 の四角形にする
+func (g RestrictionGeometry) polygons(buffer float64) ([][][][]float64, error) {
+	var result [][][][]float64
+	switch g.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		result = [][][][]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(g.Coordinates, &result); err != nil {
+			return nil, err
+		}
+	case "LineString":
+		var line [][]float64
+		if err := json.Unmarshal(g.Coordinates, &line); err != nil {
+			return nil, err
+		}
+		if len(line) < 2 {
+			return nil, fmt.Errorf("LineString needs at least 2 positions")
+		}
+		for i := 0; i+1 < len(line); i++ {
+			if len(line[i]) < 2 || len(line[i+1]) < 2 {
+				return nil, fmt.Errorf("position %d must be [lon, lat]", i)
+			}
+			result = append(result, [][][]float64{bufferSegment(line[i], line[i+1], buffer)})
+		}
+	case "Point":
+		var p []float64
+		if err := json.Unmarshal(g.Coordinates, &p); err != nil {
+			return nil, err
+		}
+		if len(p) < 2 {
+			return nil, fmt.Errorf("Point must be [lon, lat]")
+		}
+		result = [][][][]float64{{bufferSegment(p, p, buffer)}}
+	default:
+		return nil, fmt.Errorf("unsupported type %q (Polygon, MultiPolygon, LineString or Point)", g.Type)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no polygons")
+	}
+
+	for i, polygon := range result {
+		if len(polygon) == 0 {
+			return nil, fmt.Errorf("polygon %d has no rings", i)
+		}
+		for j, ring := range polygon {
+			for _, p := range ring {
+				if len(p) < 2 || p[0] < -180 || p[0] > 180 || p[1] < -90 || p[1] > 90 {
+					return nil, fmt.Errorf("polygon %d ring %d has an invalid position: %v", i, j, p)
+				}
+			}
+			// ORS は閉じたリングだけを受け付ける
+			if len(ring) > 0 && !slices.Equal(ring[0][:2], ring[len(ring)-1][:2]) {
+				ring = append(ring, ring[0])
+				polygon[j] = ring
+			}
+			if len(ring) < 4 {
+				return nil, fmt.Errorf("polygon %d ring %d needs at least 4 positions", i, j)
+			}
+		}
+	}
+	return result, nil
+}
+
+// bufferSegment は線分 a-b を両側と両端に buffer(m) 広げた四角形 (反時計回りの閉じたリング) を返す
+func bufferSegment(a, b []float64, buffer float64) [][]float64 {
+	lonPerMeter, latPerMeter := metersToDegrees(1, (a[1]+b[1])/2)
+	dx, dy := toLocalMeters(a, b)
+	length := math.Hypot(dx, dy)
+	// 進行方向の単位ベクトル (点の場合は東向き)
+	ux, uy := 1.0, 0.0
+	if length > 0 {
+		ux, uy = dx/length, dy/length
+	}
+	corner := func(p []float64, along, side float64) []float64 {
+		x := ux*along - uy*side
+		y := uy*along + ux*side
+		return []float64{p[0] + x*lonPerMeter, p[1] + y*latPerMeter}
+	}
+	first := corner(a, -buffer, -buffer)
+	return [][]float64{
+		first,
+		corner(b, buffer, -buffer),
+		corner(b, buffer, buffer),
+		corner(a, -buffer, buffer),
+		first,
+	}
+}
+
+// validate は期間・時間帯の形式を確かめる
+func (w RestrictionWindow) validate() error {
+	if w.Start != nil && w.End != nil && !w.Start.Before(*w.End) {
+		return fmt.Errorf("start must be before end")
+	}
+	for _, d := range w.Days {
+		if !slices.Contains(restrictionDays, d) {
+			return fmt.Errorf("days must be %s", strings.Join(restrictionDays, ", "))
+		}
+	}
+	if (w.StartTime == "") != (w.EndTime == "") {
+		return fmt.Errorf("start_time and end_time must be set together")
+	}
+	if w.StartTime != "" {
+		if _, err := parseClock(w.StartTime); err != nil {
+			return err
+		}
+		if _, err := parseClock(w.EndTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseClock は "HH:MM" を0時からの分にする
+func parseClock(v string) (int, error) {
+	h, m, ok := strings.Cut(v, ":")
+	hour, errH := strconv.Atoi(h)
+	minute, errM := strconv.Atoi(m)
+	if !ok || errH != nil || errM != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("time must be HH:MM: %q", v)
+	}
+	return hour*60 + minute, nil
+}
+
+// ActiveAt は t の時点で有効かを返す
+func (w RestrictionWindow) ActiveAt(t time.Time) bool {
+	if w.Start != nil && t.Before(*w.Start) {
+		return false
+	}
+	if w.End != nil && !t.Before(*w.End) {
+		return false
+	}
+	local := t.In(restrictionLocation)
+	onDay := func(day time.Weekday) bool {
+		return len(w.Days) == 0 || slices.Contains(w.Days, restrictionDays[day])
+	}
+	if w.StartTime == "" {
+		return onDay(local.Weekday())
+	}
+	start, _ := parseClock(w.StartTime)
+	end, _ := parseClock(w.EndTime)
+	now := local.Hour()*60 + local.Minute()
+	if start < end {
+		return onDay(local.Weekday()) && now >= start && now < end
+	}
+	// 日をまたぐ時間帯は、開始した日の曜日で判定する
+	if now >= start {
+		return onDay(local.Weekday())
+	}
+	return now < end && onDay((local.Weekday()+6)%7)
+}
+
+// ActiveAt は t の時点で規制が有効かを返す
+func (r Restriction) ActiveAt(t time.Time) bool {
+	if len(r.Windows) == 0 {
+		return true
+	}
+	for _, w := range r.Windows {
+		if w.ActiveAt(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictionStore は規制を RESTRICTIONS_FILE (デフォルト data/restrictions.json) から読み込み、変更を書き戻す
+// ファイルを直接書き換えた場合も他のデータセットと同じく読み込み直す
+type restrictionStore struct {
+	mu      sync.Mutex // 書き込みは1つずつ
+	dataset *Dataset[Restriction]
+}
+
+var restrictionData = &restrictionStore{
+	dataset: newDataset("restrictions", "RESTRICTIONS_FILE", "data/restrictions.json", ParseRestrictions),
+}
+
+// restrictions は規制のストアを返す
+func restrictions() *restrictionStore {
+	return restrictionData
+}
+
+func (s *restrictionStore) list() []Restriction {
+	return slices.Clone(s.dataset.Get())
+}
+
+// save は items を書き出し、成功した場合だけ入れ替える (s.mu を持って呼ぶ)
+func (s *restrictionStore) save(items []Restriction) error {
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.dataset.Path(), data); err != nil {
+		return err
+	}
+	s.dataset.replace(items)
+	return nil
+}
+
+// put は規制を追加する。replace の場合は同じ id の規制を置き換える
+func (s *restrictionStore) put(r Restriction, replace bool) (Restriction, error) {
+	if err := r.prepare(); err != nil {
+		return Restriction{}, restrictionValidationError{err}
+	}
+	r.UpdatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := slices.Clone(s.dataset.Get())
+	i := slices.IndexFunc(items, func(existing Restriction) bool { return existing.ID == r.ID })
+	switch {
+	case i < 0:
+		items = append(items, r)
+	case replace:
+		items[i] = r
+	default:
+		return Restriction{}, errRestrictionExists
+	}
+	return r, s.save(items)
+}
+
+func (s *restrictionStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.dataset.Get()
+	items := slices.DeleteFunc(slices.Clone(current), func(r Restriction) bool { return r.ID == id })
+	if len(items) == len(current) {
+		return errRestrictionNotFound
+	}
+	return s.save(items)
+}
+
+// replace は規制を全て置き換える (ParseRestrictions で確かめたものを渡す)
+func (s *restrictionStore) replace(items []Restriction) error {
+	now := time.Now()
+	for i := range items {
+		if items[i].UpdatedAt.IsZero() {
+			items[i].UpdatedAt = now
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(items)
+}
+
+// ActiveRestrictions は at の時点で有効で、waypoints を囲む範囲 (余白付き) に掛かる規制を返す
+func ActiveRestrictions(waypoints []Coordinate, at time.Time) []Restriction {
+	if len(waypoints) == 0 {
+		return nil
+	}
+	west, south, east, north := waypoints[0][0], waypoints[0][1], waypoints[0][0], waypoints[0][1]
+	for _, p := range waypoints[1:] {
+		west, south, east, north = min(west, p[0]), min(south, p[1]), max(east, p[0]), max(north, p[1])
+	}
+	span := haversineMeters([]float64{west, south}, []float64{east, north})
+	lonDeg, latDeg := metersToDegrees(max(restrictionMinMarginMeters, span*restrictionMarginRatio), (south+north)/2)
+	west, south, east, north = west-lonDeg, south-latDeg, east+lonDeg, north+latDeg
+
+	var result []Restriction
+	for _, r := range restrictions().list() {
+		if r.bbox[2] < west || r.bbox[0] > east || r.bbox[3] < south || r.bbox[1] > north {
+			continue
+		}
+		if r.ActiveAt(at) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// withActiveRestrictions は at の時点で有効な規制を回避範囲に加えたルートオプションと、加えた規制の id を返す
+// 有効な規制が無ければ options をそのまま返す (options 自体は変えない)
+func withActiveRestrictions(options *ORSRouteOptions, waypoints []Coordinate, at time.Time) (*ORSRouteOptions, []string) {
+	active := ActiveRestrictions(waypoints, at)
+	if len(active) == 0 {
+		return options, nil
+	}
+	var polygons [][][][]float64
+	ids := make([]string, 0, len(active))
+	for _, r := range active {
+		polygons = append(polygons, r.polygons...)
+		ids = append(ids, r.ID)
+	}
+	merged := ORSRouteOptions{}
+	if options != nil {
+		merged = *options
+	}
+	merged.AvoidPolygons = map[string]interface{}{
+		"type":        "MultiPolygon",
+		"coordinates": slices.Concat(avoidPolygonCoordinates(merged.AvoidPolygons), polygons),
+	}
+	return &merged, ids
+}
+
+// avoidPolygonCoordinates はルートオプションの回避範囲を MultiPolygon の座標にする
+func avoidPolygonCoordinates(avoid map[string]interface{}) [][][][]float64 {
+	switch coordinates := avoid["coordinates"].(type) {
+	case [][][][]float64:
+		if avoid["type"] == "MultiPolygon" {
+			return coordinates
+		}
+	case [][][]float64:
+		if avoid["type"] == "Polygon" {
+			return [][][][]float64{coordinates}
+		}
+	}
+	return nil
+}
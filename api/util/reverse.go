@@ -0,0 +1,402 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReverseGeocoder は座標から住所を求めるサービス
+// Geocoder のうち逆ジオコーディングに対応しているものが実装する
+type ReverseGeocoder interface {
+	Name() string
+	Reverse(ctx context.Context, lon, lat float64) (ReverseResponse, error)
+}
+
+// NearbyPlace は座標の近くにある名前付きの地点
+type NearbyPlace struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Category   string    `json:"category"`
+	Coordinate []float64 `json:"coordinate"` // [経度, 緯度]
+	Distance   float64   `json:"distance"`   // 問い合わせた座標からの距離(m)
+}
+
+// ReverseResponse は逆ジオコーディングの結果
+type ReverseResponse struct {
+	Coordinate   []float64    `json:"coordinate"`              // 問い合わせた座標 [経度, 緯度]
+	Address      string       `json:"address"`                 // 整形した住所 (東京都千代田区永田町一丁目)
+	Ward         string       `json:"ward"`                    // 区市町村 (千代田区)
+	Locality     string       `json:"locality,omitempty"`      // 町丁目 (永田町一丁目)
+	NearestPlace *NearbyPlace `json:"nearest_place,omitempty"` // 近くの名前付きの地点 (バス停・駅・交差点など)
+	Source       string       `json:"source"`                  // 住所を返したジオコーダ
+}
+
+// 近くの地点として扱う距離
+const nearestPlaceMaxMeters = 300.0
+
+// GetReverse godoc
+// @Summary 逆ジオコーディング
+// @Description 座標から住所・区・近くの名前付きの地点を返す。GEOCODER_ORDER の順にジオコーダを試し、結果はキャッシュする
+// @Tags map
+// @Accept json
+// @Produce json
+// @Param lat query number true "緯度" example(35.675895)
+// @Param lon query number true "経度" example(139.746306)
+// @Success 200 {object} ReverseResponse
+// @Failure 400 {object} ErrorResponse "座標の指定が不正"
+// @Failure 404 {object} ErrorResponse "住所が見つからない"
+// @Failure 429 {object} ErrorResponse "Nominatimのリクエスト予算超過"
+// @Failure 502 {object} ErrorResponse "全てのジオコーダで失敗"
+// @Router /reverse [get]
+func GetReverse(c *gin.Context) {
+	lat, latErr := strconv.ParseFloat(c.Query("lat"), 64)
+	lon, lonErr := strconv.ParseFloat(c.Query("lon"), 64)
+	if latErr != nil || lonErr != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid coordinate", Message: "lat and lon query parameters are required"})
+		return
+	}
+
+	resp, err := ReverseGeocode(c.Request.Context(), lon, lat)
+	if err != nil {
+		status := UpstreamStatus(err)
+		if status == http.StatusTooManyRequests {
+			c.Header("Retry-After", RetryAfterSeconds(NominatimUpstream()))
+		}
+		c.JSON(status, ErrorResponse{Error: "Failed to fetch data", Message: err.Error()})
+		return
+	}
+	if resp.Address == "" && resp.NearestPlace == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Not found", Message: "no address found for the coordinate"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ReverseGeocode は逆ジオコーディングに対応したジオコーダを順に試し、最初に住所を返したものの結果を返す
+// 近くの名前付きの地点は常にローカルの地名辞書から探す
+func ReverseGeocode(ctx context.Context, lon, lat float64) (ReverseResponse, error) {
+	initGeocodeCache()
+	// 約1mの精度でキャッシュする
+	cacheKey := fmt.Sprintf("%.5f,%.5f", lon, lat)
+	if cached, ok := reverseCache.Get(cacheKey); ok {
+		return cached, nil
+	}
+
+	result := ReverseResponse{Coordinate: []float64{lon, lat}}
+	var lastErr error
+	succeeded := false
+	for _, g := range Geocoders() {
+		r, ok := g.(ReverseGeocoder)
+		if !ok {
+			continue
+		}
+		resp, err := r.Reverse(ctx, lon, lat)
+		if err != nil {
+			fmt.Printf("reverse geocoder %s error: %v\n", r.Name(), err)
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		succeeded = true
+		if resp.Address != "" {
+			resp.Coordinate = result.Coordinate
+			resp.Source = r.Name()
+			result = resp
+			break
+		}
+	}
+	if !succeeded && lastErr != nil {
+		return ReverseResponse{}, lastErr
+	}
+	result.NearestPlace = nearestGazetteerPlace(lon, lat, nearestPlaceMaxMeters)
+	reverseCache.Set(cacheKey, result)
+	return result, nil
+}
+
+// PlaceName は座標を「千代田区永田町一丁目付近」のような名前にする
+// 住所が分からない場合は近くの地点の名前を使う
+func PlaceName(ctx context.Context, coordinate []float64) (string, error) {
+	resp, err := ReverseGeocode(ctx, coordinate[0], coordinate[1])
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case resp.Locality != "":
+		return resp.Ward + resp.Locality + "付近", nil
+	case resp.NearestPlace != nil:
+		return resp.NearestPlace.Name + "付近", nil
+	case resp.Address != "":
+		return resp.Address + "付近", nil
+	}
+	return "", nil
+}
+
+// nearestGazetteerPlace は地名辞書のうち最も近い地点を返す
+// 取締強化交差点は名前が住所なので除く
+func nearestGazetteerPlace(lon, lat, maxMeters float64) *NearbyPlace {
+	point := []float64{lon, lat}
+	var nearest *NearbyPlace
+	for _, e := range Gazetteer() {
+		if e.Category == "warning_intersection" {
+			continue
+		}
+		d := haversineMeters(point, e.Coordinate)
+		if d > maxMeters || (nearest != nil && d >= nearest.Distance) {
+			continue
+		}
+		nearest = &NearbyPlace{
+			ID:         e.ID,
+			Name:       e.Name,
+			Category:   e.Category,
+			Coordinate: e.Coordinate,
+			Distance:   math.Round(d),
+		}
+	}
+	return nearest
+}
+
+// =================Nominatim=================
+
+// nominatimReverse は Nominatim の /reverse?format=jsonv2 のレスポンス
+type nominatimReverse struct {
+	Error       string            `json:"error"`
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	Address     map[string]string `json:"address"`
+}
+
+// 日本の住所として並べる Nominatim の住所の要素 (大きい単位から)
+var nominatimAddressKeys = []string{"province", "state", "city", "city_district", "suburb", "quarter", "neighbourhood", "city_block", "house_number"}
+
+func (g *nominatimGeocoder) Reverse(ctx context.Context, lon, lat float64) (ReverseResponse, error) {
+	values := url.Values{}
+	values.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	values.Set("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+	values.Set("format", "jsonv2")
+	values.Set("accept-language", "ja")
+	values.Set("zoom", "18")
+	reqURL := strings.TrimRight(g.baseURL, "/") + "/reverse?" + values.Encode()
+
+	body, err := g.fetch(ctx, reqURL)
+	if err != nil {
+		return ReverseResponse{}, err
+	}
+	var place nominatimReverse
+	if err := json.Unmarshal(body, &place); err != nil {
+		return ReverseResponse{}, fmt.Errorf("failed to parse %s response: %w", g.name, err)
+	}
+	if place.Error != "" {
+		return ReverseResponse{}, nil
+	}
+
+	var parts []string
+	for _, key := range nominatimAddressKeys {
+		if v := place.Address[key]; v != "" {
+			parts = append(parts, v)
+		}
+	}
+	ward := place.Address["city_district"]
+	if !strings.HasSuffix(ward, "区") {
+		ward = place.Address["city"]
+	}
+	locality := place.Address["neighbourhood"]
+	if locality == "" {
+		locality = place.Address["quarter"]
+	}
+	if locality == "" {
+		locality = place.Address["suburb"]
+	}
+	return ReverseResponse{
+		Address:  joinAddress(parts),
+		Ward:     ward,
+		Locality: locality,
+	}, nil
+}
+
+// joinAddress は住所の要素を連結する
+// 「永田町」「永田町一丁目」のように次の要素に含まれているものは省く
+func joinAddress(parts []string) string {
+	var b strings.Builder
+	for i, p := range parts {
+		if i+1 < len(parts) && strings.HasPrefix(parts[i+1], p) {
+			continue
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// =================国土地理院 逆ジオコーダ=================
+
+const gsiReverseGeocoderURL = "https://mreversegeocoder.gsi.go.jp/reverse-geocoder/LonLatToAddress"
+
+type gsiReverse struct {
+	Results *struct {
+		MuniCd string `json:"muniCd"`
+		Lv01Nm string `json:"lv01Nm"`
+	} `json:"results"`
+}
+
+func (g *gsiGeocoder) Reverse(ctx context.Context, lon, lat float64) (ReverseResponse, error) {
+	reqURL := gsiReverseGeocoderURL + "?lat=" + strconv.FormatFloat(lat, 'f', -1, 64) + "&lon=" + strconv.FormatFloat(lon, 'f', -1, 64)
+	body, err := fetchUpstream(ctx, GSIUpstream(), reqURL)
+	if err != nil {
+		return ReverseResponse{}, err
+	}
+	var resp gsiReverse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ReverseResponse{}, fmt.Errorf("failed to parse gsi response: %w", err)
+	}
+	if resp.Results == nil {
+		return ReverseResponse{}, nil
+	}
+
+	// muniCd は先頭0が省略されることがある
+	code := fmt.Sprintf("%05s", resp.Results.MuniCd)
+	ward := tokyoMunicipalities[code]
+	locality := strings.TrimSpace(resp.Results.Lv01Nm)
+	if locality == "－" || locality == "-" {
+		locality = ""
+	}
+	address := ward + locality
+	if ward != "" {
+		address = "東京都" + address
+	}
+	return ReverseResponse{Address: address, Ward: ward, Locality: locality}, nil
+}
+
+// =================地名辞書=================
+
+// 町丁目の名前として扱う地名辞書のカテゴリ
+var localityCategories = map[string]bool{"neighbourhood": true, "quarter": true, "block": true, "suburb": true}
+
+func (g *gazetteerGeocoder) Reverse(ctx context.Context, lon, lat float64) (ReverseResponse, error) {
+	point := []float64{lon, lat}
+	var locality GazetteerEntry
+	best := math.Inf(1)
+	ward := ""
+	wardDistance := math.Inf(1)
+	for _, e := range Gazetteer() {
+		d := haversineMeters(point, e.Coordinate)
+		if localityCategories[e.Category] && d < best && d <= 1000 {
+			locality, best = e, d
+		}
+		// 区は近くの地名の住所や「千代田区永田町1丁目付近」のような名前から推定する
+		if d < wardDistance && d <= 1000 {
+			if w := wardOf(e.Address); w != "" {
+				ward, wardDistance = w, d
+			} else if w := wardOf(e.Name); w != "" {
+				ward, wardDistance = w, d
+			}
+		}
+	}
+	if locality.Name == "" && ward == "" {
+		return ReverseResponse{}, nil
+	}
+	address := ward + locality.Name
+	if ward != "" {
+		address = "東京都" + address
+	}
+	return ReverseResponse{Address: address, Ward: ward, Locality: locality.Name}, nil
+}
+
+// wardOf は住所の先頭の区市町村名を返す (「東京都」は省略可)
+func wardOf(address string) string {
+	address = strings.TrimPrefix(address, "東京都")
+	for _, name := range tokyoMunicipalities {
+		if strings.HasPrefix(address, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// 東京都の区市の全国地方公共団体コード
+var tokyoMunicipalities = map[string]string{
+	"13101": "千代田区", "13102": "中央区", "13103": "港区", "13104": "新宿区",
+	"13105": "文京区", "13106": "台東区", "13107": "墨田区", "13108": "江東区",
+	"13109": "品川区", "13110": "目黒区", "13111": "大田区", "13112": "世田谷区",
+	"13113": "渋谷区", "13114": "中野区", "13115": "杉並区", "13116": "豊島区",
+	"13117": "北区", "13118": "荒川区", "13119": "板橋区", "13120": "練馬区",
+	"13121": "足立区", "13122": "葛飾区", "13123": "江戸川区",
+	"13201": "八王子市", "13202": "立川市", "13203": "武蔵野市", "13204": "三鷹市",
+	"13205": "青梅市", "13206": "府中市", "13207": "昭島市", "13208": "調布市",
+	"13209": "町田市", "13210": "小金井市", "13211": "小平市", "13212": "日野市",
+	"13213": "東村山市", "13214": "国分寺市", "13215": "国立市", "13218": "福生市",
+	"13219": "狛江市", "13220": "東大和市", "13221": "清瀬市", "13222": "東久留米市",
+	"13223": "武蔵村山市", "13224": "多摩市", "13225": "稲城市", "13227": "羽村市",
+	"13228": "あきる野市", "13229": "西東京市",
+}
+
+// =================危険箇所の名前=================
+
+var (
+	hazardNames    sync.Map // 座標の文字列 -> 名前
+	hazardNamingMu sync.Mutex
+)
+
+func hazardNameKey(coordinate []float64) string {
+	return fmt.Sprintf("%.6f,%.6f", coordinate[0], coordinate[1])
+}
+
+// HazardName は NameHazards で付けた地点の名前を返す
+func HazardName(coordinate []float64) string {
+	if len(coordinate) != 2 {
+		return ""
+	}
+	if name, ok := hazardNames.Load(hazardNameKey(coordinate)); ok {
+		return name.(string)
+	}
+	return ""
+}
+
+// NameHazards は名前の無い取締強化交差点・事故多発地点と違反率の交差点に逆ジオコーディングで名前を付ける
+// 外部APIの予算を使うため起動時とデータセットの読み込み直しの後にバックグラウンドで1件ずつ実行し、名前の付いた地点は飛ばす
+func NameHazards(ctx context.Context) {
+	hazardNamingMu.Lock()
+	defer hazardNamingMu.Unlock()
+
+	var coordinates [][]float64
+	for _, w := range WarningIntersections() {
+		if w.Name == "" && len(w.Coordinate) == 2 {
+			coordinates = append(coordinates, w.Coordinate)
+		}
+	}
+	for _, h := range AccidentHotspots() {
+		if h.Name == "" && len(h.Coordinate) == 2 {
+			coordinates = append(coordinates, h.Coordinate)
+		}
+	}
+	for _, v := range ViolationRates() {
+		if v.Name == "" && len(v.Coordinate) == 2 {
+			coordinates = append(coordinates, v.Coordinate)
+		}
+	}
+	for _, coordinate := range coordinates {
+		if ctx.Err() != nil {
+			return
+		}
+		if HazardName(coordinate) != "" {
+			continue
+		}
+		name, err := PlaceName(ctx, coordinate)
+		if err != nil {
+			fmt.Println("hazard naming error:", err)
+			continue
+		}
+		if name != "" {
+			hazardNames.Store(hazardNameKey(coordinate), name)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultCorridorMeters = 150.0
+	maxCorridorMeters     = 1000.0
+	defaultRoutePOILimit  = 20
+	maxRoutePOILimit      = 100
+	// プロファイル未指定の場合に寄り道の所要時間の計算に使う速度
+	defaultRoutePOISpeedKmh = 15.0
+)
+
+// 立ち寄り先として探すカテゴリ (category 未指定の場合)
+var defaultRoutePOICategories = []string{"convenience", "compressed_air", "bicycle_shop", "bicycle_repair", "toilets"}
+
+// RoutePOI はルート沿いの地点
+type RoutePOI struct {
+	ID                 string    `json:"id"`
+	Name               string    `json:"name,omitempty"`
+	Category           string    `json:"category"`
+	Coordinate         []float64 `json:"coordinate"`           // [経度, 緯度]
+	SnappedCoordinate  []float64 `json:"snapped_coordinate"`   // ルート上で最も近い位置 [経度, 緯度]
+	DistanceAlongRoute float64   `json:"distance_along_route"` // 出発地から SnappedCoordinate までのルート上の距離(m)
+	DistanceFromRoute  float64   `json:"distance_from_route"`  // ルートからの直線距離(m)
+	DetourMeters       float64   `json:"detour_meters"`        // 寄り道で増える距離(m, ルートから往復する直線距離)
+	DetourSeconds      float64   `json:"detour_seconds"`       // 寄り道で増える所要時間(秒)
+}
+
+// RoutePOIResponse はルート沿いの地点の検索結果
+type RoutePOIResponse struct {
+	SessionID     string     `json:"session_id"`
+	Categories    []string   `json:"categories"`
+	BufferMeters  float64    `json:"buffer_meters"`  // 探したルートからの距離(m)
+	RouteDistance float64    `json:"route_distance"` // ルートの全長(m)
+	POIs          []RoutePOI `json:"pois"`
+}
+
+// GetSessionPOIs godoc
+// @Summary ルート沿いの立ち寄り先検索
+// @Description 経路検索の session_id のルートから buffer (m) 以内にあるコンビニ・空気入れ・自転車店・トイレなどを、出発地からのルート上の距離と寄り道のコストの順に返す。地点は data/pois.json (prepare_poi で OpenStreetMap から作成) から探す
+// @Tags map
+// @Accept json
+// @Produce json
+// @Param id path string true "/directions/bicycle などのレスポンス内の session_id"
+// @Param category query string false "カテゴリ (カンマ区切り, デフォルト convenience,compressed_air,bicycle_shop,bicycle_repair,toilets)"
+// @Param buffer query number false "ルートからの距離の上限(m, 1-1000, デフォルト150)"
+// @Param sort query string false "並べ順 route: ルート上の距離順 (デフォルト), detour: 寄り道のコスト順"
+// @Param limit query int false "件数の上限 (1-100, デフォルト20)"
+// @Param profile query string false "寄り道の所要時間の計算に使うライダープロファイル"
+// @Success 200 {object} RoutePOIResponse
+// @Failure 400 {object} ErrorResponse "パラメータ不正"
+// @Failure 404 {object} ErrorResponse "session_id のルートが無い"
+// @Router /sessions/{id}/pois [get]
+func GetSessionPOIs(c *gin.Context) {
+	sessionID := c.Param("id")
+	geometry, ok := SessionGeometry(sessionID)
+	if !ok || len(geometry.Coordinates) < 2 {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Session not found", Message: fmt.Sprintf("no route for session_id: %s", sessionID)})
+		return
+	}
+
+	categories := defaultRoutePOICategories
+	if v := c.Query("category"); v != "" {
+		categories = nil
+		for _, category := range strings.Split(v, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				categories = append(categories, category)
+			}
+		}
+	}
+	buffer := defaultCorridorMeters
+	if v := c.Query("buffer"); v != "" {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil || b <= 0 || b > maxCorridorMeters {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid buffer", Message: "buffer must be between 1 and 1000"})
+			return
+		}
+		buffer = b
+	}
+	limit := defaultRoutePOILimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRoutePOILimit {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid limit", Message: "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+	byDetour := false
+	switch c.DefaultQuery("sort", "route") {
+	case "route":
+	case "detour":
+		byDetour = true
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid sort", Message: "sort must be route or detour"})
+		return
+	}
+	profile := RiderProfile{SpeedKmh: defaultRoutePOISpeedKmh}
+	if name := c.Query("profile"); name != "" {
+		p, found := LookupRiderProfile(name)
+		if !found {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid profile", Message: fmt.Sprintf("unknown profile: %s", name)})
+			return
+		}
+		profile = p
+	}
+
+	pois := POIsAlongRoute(geometry.Coordinates, POIsByCategory(categories...), buffer, profile)
+	if byDetour {
+		sort.SliceStable(pois, func(i, j int) bool { return pois[i].DetourMeters < pois[j].DetourMeters })
+	}
+	if len(pois) > limit {
+		pois = pois[:limit]
+	}
+	c.JSON(http.StatusOK, RoutePOIResponse{
+		SessionID:     sessionID,
+		Categories:    categories,
+		BufferMeters:  buffer,
+		RouteDistance: math.Round(routeLength(geometry.Coordinates)),
+		POIs:          pois,
+	})
+}
+
+// POIsAlongRoute はルートから bufferMeters 以内にある地点を、ルート上の距離・寄り道の距離の順に返す
+// 寄り道の距離はルート上の最も近い位置から地点までを往復する直線距離とする
+func POIsAlongRoute(route [][]float64, pois []POI, bufferMeters float64, profile RiderProfile) []RoutePOI {
+	result := []RoutePOI{}
+	if len(route) < 2 {
+		return result
+	}
+
+	// 各頂点までのルート上の距離と、線分ごとに buffer だけ広げた範囲 (度) を先に求めておく
+	cumulative := make([]float64, len(route))
+	bounds := make([][4]float64, len(route)-1) // 西, 南, 東, 北
+	for i := 1; i < len(route); i++ {
+		a, b := route[i-1], route[i]
+		cumulative[i] = cumulative[i-1] + haversineMeters(a, b)
+		latMargin := bufferMeters / (earthRadiusMeters * math.Pi / 180)
+		lonMargin := latMargin / math.Cos(a[1]*math.Pi/180)
+		bounds[i-1] = [4]float64{
+			math.Min(a[0], b[0]) - lonMargin,
+			math.Min(a[1], b[1]) - latMargin,
+			math.Max(a[0], b[0]) + lonMargin,
+			math.Max(a[1], b[1]) + latMargin,
+		}
+	}
+
+	for _, p := range pois {
+		if len(p.Coordinate) != 2 {
+			continue
+		}
+		best := math.Inf(1)
+		var along float64
+		var snapped []float64
+		for i, bb := range bounds {
+			if p.Coordinate[0] < bb[0] || p.Coordinate[0] > bb[2] || p.Coordinate[1] < bb[1] || p.Coordinate[1] > bb[3] {
+				continue
+			}
+			a, b := route[i], route[i+1]
+			d, t := pointSegmentDistanceMeters(p.Coordinate, a, b)
+			if d < best {
+				best = d
+				along = cumulative[i] + t*(cumulative[i+1]-cumulative[i])
+				snapped = pointOnSegment(a, b, t)
+			}
+		}
+		if best > bufferMeters {
+			continue
+		}
+		detour := 2 * best
+		result = append(result, RoutePOI{
+			ID:                 p.ID,
+			Name:               p.Name,
+			Category:           p.Category,
+			Coordinate:         p.Coordinate,
+			SnappedCoordinate:  snapped,
+			DistanceAlongRoute: math.Round(along),
+			DistanceFromRoute:  math.Round(best),
+			DetourMeters:       math.Round(detour),
+			DetourSeconds:      math.Round(profile.EstimatedDuration(detour)),
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].DistanceAlongRoute != result[j].DistanceAlongRoute {
+			return result[i].DistanceAlongRoute < result[j].DistanceAlongRoute
+		}
+		return result[i].DetourMeters < result[j].DetourMeters
+	})
+	return result
+}
+
+// routeLength はルートの全長(m)を返す
+func routeLength(route [][]float64) float64 {
+	total := 0.0
+	for i := 1; i < len(route); i++ {
+		total += haversineMeters(route[i-1], route[i])
+	}
+	return total
+}
@@ -0,0 +1,76 @@
+package util
+
+import (
+	"math"
+)
+
+// 経路の真上にあるとみなす距離(m)
+const onRouteMeters = 5.0
+
+// 経路沿いの注意点の左右
+const (
+	SideLeft    = "left"
+	SideRight   = "right"
+	SideOnRoute = "on_route"
+)
+
+// RouteWarningPoint は経路沿いの注意点
+type RouteWarningPoint struct {
+	WarningPoint
+	DistanceAlongRoute float64 `json:"distance_along_route"` // 出発地から注意点の手前までの経路上の距離(m)
+	DistanceFromRoute  float64 `json:"distance_from_route"`  // 経路からの距離(m)
+	StepIndex          int     `json:"step_index"`           // 注意点を通る案内の番号 (全 segments の steps を通した番号, 見つからない場合は -1)
+	Side               string  `json:"side"`                 // 進行方向に対する左右 (left, right, on_route)
+}
+
+// RouteWarningPoints は経路 feature から WARNING_POINT_ROUTE_DISTANCE (m, デフォルト30) 以内の取締強化交差点・事故多発地点・承認済みの報告を、
+// ライダーが通る順に返す。メッセージは lang で作る
+func RouteWarningPoints(feature ORSFeature, lang string) []RouteWarningPoint {
+	result := []RouteWarningPoint{}
+	line := feature.Geometry.Coordinates
+	if len(line) == 0 {
+		return result
+	}
+	for _, m := range WarningPointIndex().AlongPolyline(line, warningPointCorridorMeters()) {
+		// /warning_point と同じく種類・名前・区市町村を補う
+		point := m.Value.withDefaults().Localize(lang)
+		result = append(result, RouteWarningPoint{
+			WarningPoint:       point,
+			DistanceAlongRoute: math.Round(m.DistanceAlongRoute),
+			DistanceFromRoute:  math.Round(m.Distance),
+			StepIndex:          stepIndexAt(feature.Properties.Segments, m.Segment),
+			Side:               sideOfRoute(line, m.Segment, m.Coordinate, m.Distance),
+		})
+	}
+	return result
+}
+
+// stepIndexAt は頂点 vertex から始まる線分を含む案内の番号を返す
+// ORS の step の way_points は [始点の頂点, 終点の頂点]
+func stepIndexAt(segments []ORSSegment, vertex int) int {
+	index := 0
+	for _, segment := range segments {
+		for _, step := range segment.Steps {
+			if len(step.WayPoints) == 2 && vertex >= step.WayPoints[0] && vertex < step.WayPoints[1] {
+				return index
+			}
+			index++
+		}
+	}
+	return -1
+}
+
+// sideOfRoute は点 p が線分 line[segment]-line[segment+1] の進行方向の左右どちらにあるかを返す
+func sideOfRoute(line [][]float64, segment int, p []float64, distance float64) string {
+	if distance <= onRouteMeters || segment+1 >= len(line) {
+		return SideOnRoute
+	}
+	a, b := line[segment], line[segment+1]
+	bx, by := toLocalMeters(a, b)
+	px, py := toLocalMeters(a, p)
+	// 外積が正なら進行方向の左
+	if bx*py-by*px > 0 {
+		return SideLeft
+	}
+	return SideRight
+}
@@ -1,97 +1,173 @@
 package util
 
 import (
-	"encoding/json"
-	"io"
+	"context"
+	"fmt"
 	"net/http"
-	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	_ "template-mobile-app-api/docs"
+	"template-mobile-app-api/jpnorm"
 
 	"github.com/gin-gonic/gin"
 )
 
+// SearchResponse は検索結果の地点
+// どのジオコーダの結果も Nominatim のレスポンスに合わせた形に揃える
 type SearchResponse struct {
 	// https://nominatim.openstreetmap.org/search?q={Client input}&format=json&limit=5
 	// のレスポンスを構造体に
-	PlaceID     int      `json:"place_id"`     // Nominatim 内での一意なID
-	Licence     string   `json:"licence"`      // データのライセンス情報
-	OsmType     string   `json:"osm_type"`     // OSM 要素の種類 (node, way, relation)
-	OsmID       int64    `json:"osm_id"`       // OSM 内の要素ID
-	Lat         string   `json:"lat"`          // 緯度
-	Lon         string   `json:"lon"`          // 経度
-	Class       string   `json:"class"`        // 分類（例: railway, building, amenity など）
-	Type        string   `json:"type"`         // 分類のサブタイプ（例: stop, station, bus_station など）
-	PlaceRank   int      `json:"place_rank"`   // 検索結果の粒度を示すランク
-	Importance  float64  `json:"importance"`   // 検索結果の重要度スコア
-	AddressType string   `json:"addresstype"`  // アドレスの種類（例: city, house, railway）
-	Name        string   `json:"name"`         // OSM に登録されている名前
-	DisplayName string   `json:"display_name"` // 住所や施設名などの連結
-	BoundingBox []string `json:"boundingbox"`  // 範囲 [南緯, 北緯, 西経, 東経]
+	PlaceID     int64    `json:"place_id"`              // Nominatim 内での一意なID (Nominatim 以外の結果は 0)
+	ID          string   `json:"id"`                    // 全てのジオコーダを通して一意なID (nominatim.{place_id}, gsi.…, 地名辞書のID)
+	Licence     string   `json:"licence,omitempty"`     // データのライセンス情報
+	OsmType     string   `json:"osm_type,omitempty"`    // OSM 要素の種類 (node, way, relation)
+	OsmID       int64    `json:"osm_id,omitempty"`      // OSM 内の要素ID
+	Lat         string   `json:"lat"`                   // 緯度
+	Lon         string   `json:"lon"`                   // 経度
+	Class       string   `json:"class"`                 // 分類（例: railway, building, amenity など）
+	Type        string   `json:"type"`                  // 分類のサブタイプ（例: stop, station, bus_station など）
+	PlaceRank   int      `json:"place_rank,omitempty"`  // 検索結果の粒度を示すランク
+	Importance  float64  `json:"importance,omitempty"`  // 検索結果の重要度スコア
+	AddressType string   `json:"addresstype,omitempty"` // アドレスの種類（例: city, house, railway）
+	Name        string   `json:"name"`                  // 名前
+	DisplayName string   `json:"display_name"`          // 住所や施設名などの連結
+	BoundingBox []string `json:"boundingbox,omitempty"` // 範囲 [南緯, 北緯, 西経, 東経]
+	Source      string   `json:"source"`                // 結果を返したジオコーダ (nominatim, nominatim_self_hosted, gsi, gazetteer)
+	Distance    float64  `json:"distance,omitempty"`    // lat, lon を指定した場合のその地点からの距離(m)
 }
 
-//TODO 定義中
+// Coordinate は検索結果の座標を [経度, 緯度] で返す
+func (s SearchResponse) Coordinate() (Coordinate, error) {
+	return ParseCoordinate(s.Lon + "," + s.Lat)
+}
 
 // getSearch godoc
 // @Summary 目的地候補検索
-// @Description Nominatimのレスポンスを横流し(https://nominatim.openstreetmap.org/search?q={Client input}&format=json&limit=5)
+// @Description GEOCODER_ORDER の順にジオコーダ(ローカルの地名辞書, 自前のNominatim, Nominatim, 国土地理院 住所検索)を試し、最初に見つかった候補を返す。lat, lon を指定すると近い順に並べる
 // @Tags map
 // @Accept json
 // @Produce json
 // @Param q query string true "検索文字列"
+// @Param lat query number false "ライダーの現在地の緯度 (近い順に並べる)" example(35.681236)
+// @Param lon query number false "ライダーの現在地の経度 (近い順に並べる)" example(139.767125)
+// @Param radius query number false "現在地からの範囲(m)。bounded=true なら範囲外を除く" example(3000)
+// @Param viewbox query string false "検索範囲 西,南,東,北" example(139.70,35.65,139.80,35.72)
+// @Param bounded query bool false "範囲外の結果を除く"
+// @Param limit query int false "件数の上限 (1-50, デフォルト5)"
+// @Param categories query string false "地点の種類 (カンマ区切り, 例: convenience,bicycle_parking)"
+// @Param lang query string false "結果の言語 (デフォルト ja)"
 // @Success 200 {object} []SearchResponse
 // @Failure 400 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse "Nominatimのリクエスト予算超過"
+// @Failure 502 {object} ErrorResponse "全てのジオコーダで検索失敗"
+// @Failure 503 {object} ErrorResponse "Nominatimの順番待ちがタイムアウト"
 // @Router /search [get]
 func GetSearch(c *gin.Context) {
 	//Client inputを取得 パラメータ: q
-	//https://nominatim.openstreetmap.org/search?q={Client input}&accept-language=ja&format=json&limit=5
-	//nominatimレスポンスをそのまま返す
 	query := c.Query("q")
-
-	resp := GetSearchBase(query, "")
-
-	if _, ok := resp.([]SearchResponse); ok {
-		c.JSON(http.StatusOK, resp)
-	} else {
-		c.JSON(http.StatusInternalServerError, resp)
+	// 空の検索文字列は結果なし (ジオコーダには問い合わせない)
+	if strings.TrimSpace(query) == "" {
+		c.JSON(http.StatusOK, []SearchResponse{})
+		return
 	}
-}
-
-func GetSearchBase(query string, query2 string) (res any) {
-	//Client inputを取得 パラメータ: q
-	//https://nominatim.openstreetmap.org/search?q={Client input}&format=json&limit=5
-	//nominatimレスポンスをそのまま返す
-	encodedQuery := url.QueryEscape(query)
-	resp, err := http.Get("https://nominatim.openstreetmap.org/search?q=" + encodedQuery + query2 + "&accept-language=ja&format=json&limit=5")
-
+	options, err := ParseSearchOptions(c)
 	if err != nil {
-		response := ErrorResponse{
-			Error:   "Failed to fetch data",
-			Message: err.Error(),
-		}
-		return response
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid search options", Message: err.Error()})
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	resp, err := GetSearchBase(c.Request.Context(), query, options)
 	if err != nil {
-		response := ErrorResponse{
-			Error:   "Failed to read response",
-			Message: err.Error(),
+		status := UpstreamStatus(err)
+		if status == http.StatusTooManyRequests {
+			c.Header("Retry-After", RetryAfterSeconds(NominatimUpstream()))
 		}
-		return response
+		c.JSON(status, ErrorResponse{Error: "Failed to fetch data", Message: err.Error()})
+		return
 	}
+	// 見つかった地点は次回以降の入力補完の候補にする
+	RecordResolvedPlaces(query, resp)
+	c.JSON(http.StatusOK, resp)
+}
 
-	var searchResponse []SearchResponse
-	if err := json.Unmarshal(body, &searchResponse); err != nil {
-		response := ErrorResponse{
-			Error:   "Failed to parse response",
-			Message: err.Error(),
+// ParseSearchOptions はクエリパラメータ (lat, lon, radius, viewbox, bounded, limit, categories, lang) から検索の条件を作る
+func ParseSearchOptions(c *gin.Context) (SearchOptions, error) {
+	var options SearchOptions
+	if c.Query("lat") != "" || c.Query("lon") != "" {
+		focus, err := ParseCoordinate(c.Query("lon") + "," + c.Query("lat"))
+		if err != nil {
+			return options, fmt.Errorf("invalid lat/lon: %v", err)
 		}
-
-		return response
+		options.Focus = &focus
+	}
+	if v := c.Query("radius"); v != "" {
+		radius, err := strconv.ParseFloat(v, 64)
+		if err != nil || radius <= 0 {
+			return options, fmt.Errorf("invalid radius: %s", v)
+		}
+		options.Radius = radius
 	}
+	if v := c.Query("viewbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return options, fmt.Errorf("viewbox must be west,south,east,north")
+		}
+		var box [4]float64
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return options, fmt.Errorf("invalid viewbox: %s", v)
+			}
+			box[i] = f
+		}
+		// 対角の2点の順番は問わない
+		box = [4]float64{min(box[0], box[2]), min(box[1], box[3]), max(box[0], box[2]), max(box[1], box[3])}
+		options.Viewbox = &box
+	}
+	if v := c.Query("bounded"); v != "" {
+		bounded, err := strconv.ParseBool(v)
+		if err != nil {
+			return options, fmt.Errorf("invalid bounded: %s", v)
+		}
+		options.Bounded = bounded
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return options, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+		options.Limit = limit
+	}
+	if v := c.Query("categories"); v != "" {
+		for _, category := range strings.Split(v, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				options.Categories = append(options.Categories, category)
+			}
+		}
+	}
+	options.Language = c.Query("lang")
+	return options, nil
+}
 
-	return searchResponse
+// GetSearchBase はジオコーダを順に試して検索する
+//
+// 検索語は jpnorm.ParseLocation で「付近」などを除き、漢数字の丁目・番地を揃えてから渡す。
+// 検索語に区市町村が含まれる場合は、その区市町村の結果を先にする。
+func GetSearchBase(ctx context.Context, query string, options SearchOptions) ([]SearchResponse, error) {
+	location := jpnorm.ParseLocation(query)
+	if q := location.Query(); q != "" {
+		query = q
+	}
+	results, err := Geocode(ctx, query, options)
+	if err != nil || location.Ward == "" {
+		return results, err
+	}
+	// Geocode の結果はキャッシュと共有しているので、並べ替える前に複製する
+	results = slices.Clone(results)
+	sort.SliceStable(results, func(i, j int) bool {
+		return strings.Contains(results[i].DisplayName, location.Ward) && !strings.Contains(results[j].DisplayName, location.Ward)
+	})
+	return results, nil
 }
@@ -2,6 +2,7 @@ package util
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/google/uuid"
 )
@@ -12,4 +13,23 @@ func GenerateSessionID() string {
 	return value
 }
 
-var SessionIDResponse = map[string]ORSGeometry{}
+// session_id -> 経路の形状 (複数のハンドラーから同時に読み書きするので sessionMu で守る)
+var (
+	sessionMu         sync.RWMutex
+	sessionIDResponse = map[string]ORSGeometry{}
+)
+
+// SaveSessionGeometry は session_id に経路の形状を保存する
+func SaveSessionGeometry(sessionID string, geometry ORSGeometry) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	sessionIDResponse[sessionID] = geometry
+}
+
+// SessionGeometry は session_id の経路の形状を返す
+func SessionGeometry(sessionID string) (ORSGeometry, bool) {
+	sessionMu.RLock()
+	defer sessionMu.RUnlock()
+	geometry, ok := sessionIDResponse[sessionID]
+	return geometry, ok
+}
@@ -0,0 +1,237 @@
+package util
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// ジオハッシュの文字
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// 索引のジオハッシュの桁数 (6桁で約1.2km×0.6kmのセル)
+const spatialIndexPrecision = 6
+
+// encodeGeohash は [経度, 緯度] を precision 桁のジオハッシュにする
+func encodeGeohash(lon, lat float64, precision int) string {
+	lonRange := [2]float64{-180, 180}
+	latRange := [2]float64{-90, 90}
+	var b strings.Builder
+	bit, ch, even := 0, 0, true
+	for b.Len() < precision {
+		r := &latRange
+		v := lat
+		if even {
+			r, v = &lonRange, lon
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			b.WriteByte(geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return b.String()
+}
+
+// geohashCellSize は precision 桁のセルの経度・緯度方向の大きさ(度)
+func geohashCellSize(precision int) (lonDeg, latDeg float64) {
+	bits := precision * 5
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 360 / math.Pow(2, float64(lonBits)), 180 / math.Pow(2, float64(latBits))
+}
+
+// SpatialMatch は索引の検索結果
+type SpatialMatch[T any] struct {
+	Value      T
+	Coordinate []float64 // [経度, 緯度]
+	Distance   float64   // 検索した点・経路からの距離(m)
+}
+
+// CorridorMatch は経路沿いの検索結果
+type CorridorMatch[T any] struct {
+	SpatialMatch[T]
+	Segment            int       // 最も近い線分の始点の頂点のインデックス
+	Snapped            []float64 // 経路上で最も近い位置 [経度, 緯度]
+	DistanceAlongRoute float64   // 経路の始点から Snapped までの距離(m)
+}
+
+// SpatialIndex はジオハッシュのグリッドで点を索引する
+// 作成後は読み取り専用で、複数のリクエストから同時に使える
+type SpatialIndex[T any] struct {
+	precision int
+	cellLon   float64
+	cellLat   float64
+	cells     map[string][]int
+	items     []spatialItem[T]
+}
+
+type spatialItem[T any] struct {
+	coordinate []float64
+	value      T
+}
+
+// NewSpatialIndex は coordinate が返す座標で values を索引する
+// 座標の無いもの (長さが2でないもの) は索引しない
+func NewSpatialIndex[T any](values []T, coordinate func(T) []float64) *SpatialIndex[T] {
+	s := &SpatialIndex[T]{precision: spatialIndexPrecision, cells: map[string][]int{}}
+	s.cellLon, s.cellLat = geohashCellSize(s.precision)
+	for _, v := range values {
+		c := coordinate(v)
+		if len(c) != 2 {
+			continue
+		}
+		key := encodeGeohash(c[0], c[1], s.precision)
+		s.cells[key] = append(s.cells[key], len(s.items))
+		s.items = append(s.items, spatialItem[T]{coordinate: c, value: v})
+	}
+	return s
+}
+
+// Len は索引している点の数を返す
+func (s *SpatialIndex[T]) Len() int {
+	return len(s.items)
+}
+
+// candidates は範囲 (西, 南, 東, 北) に掛かるセルの点のインデックスを返す
+// 範囲のセルの数が点のあるセルより多い場合は全ての点を返す (距離は呼び出し側で確かめる)
+func (s *SpatialIndex[T]) candidates(west, south, east, north float64, visit func(i int)) {
+	if (east-west)/s.cellLon*(north-south)/s.cellLat > float64(len(s.cells)) {
+		for i := range s.items {
+			visit(i)
+		}
+		return
+	}
+	// セルの大きさずつ進め、端のセルも含める
+	for lat := south; ; lat = math.Min(lat+s.cellLat, north) {
+		for lon := west; ; lon = math.Min(lon+s.cellLon, east) {
+			for _, i := range s.cells[encodeGeohash(lon, lat, s.precision)] {
+				visit(i)
+			}
+			if lon >= east {
+				break
+			}
+		}
+		if lat >= north {
+			break
+		}
+	}
+}
+
+// metersToDegrees は緯度 lat での距離 meters を経度・緯度の差(度)にする
+func metersToDegrees(meters, lat float64) (lonDeg, latDeg float64) {
+	latDeg = meters / (earthRadiusMeters * math.Pi / 180)
+	lonDeg = latDeg / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	return lonDeg, latDeg
+}
+
+// Within は center から radiusMeters 以内の点を近い順に返す
+func (s *SpatialIndex[T]) Within(center []float64, radiusMeters float64) []SpatialMatch[T] {
+	var result []SpatialMatch[T]
+	lonDeg, latDeg := metersToDegrees(radiusMeters, center[1])
+	seen := map[int]bool{}
+	s.candidates(center[0]-lonDeg, center[1]-latDeg, center[0]+lonDeg, center[1]+latDeg, func(i int) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		item := s.items[i]
+		if d := haversineMeters(center, item.coordinate); d <= radiusMeters {
+			result = append(result, SpatialMatch[T]{Value: item.value, Coordinate: item.coordinate, Distance: d})
+		}
+	})
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Distance < result[j].Distance })
+	return result
+}
+
+// Nearest は center に最も近い maxMeters 以内の点を返す
+// 探す範囲を広げながら調べるので、maxMeters が大きくても近くに点があればすぐに返る
+func (s *SpatialIndex[T]) Nearest(center []float64, maxMeters float64) (SpatialMatch[T], bool) {
+	for radius := math.Min(500, maxMeters); ; radius = math.Min(radius*4, maxMeters) {
+		if matches := s.Within(center, radius); len(matches) > 0 {
+			return matches[0], true
+		}
+		if radius >= maxMeters {
+			return SpatialMatch[T]{}, false
+		}
+	}
+}
+
+// InBounds は範囲 (西, 南, 東, 北) 内の点を返す
+func (s *SpatialIndex[T]) InBounds(west, south, east, north float64) []SpatialMatch[T] {
+	var result []SpatialMatch[T]
+	seen := map[int]bool{}
+	s.candidates(west, south, east, north, func(i int) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		c := s.items[i].coordinate
+		if c[0] >= west && c[0] <= east && c[1] >= south && c[1] <= north {
+			result = append(result, SpatialMatch[T]{Value: s.items[i].value, Coordinate: c})
+		}
+	})
+	return result
+}
+
+// AlongPolyline は経路 line から corridorMeters 以内の点を、経路の始点からの距離の順に返す
+func (s *SpatialIndex[T]) AlongPolyline(line [][]float64, corridorMeters float64) []CorridorMatch[T] {
+	var result []CorridorMatch[T]
+	if len(line) == 0 {
+		return result
+	}
+	if len(line) == 1 {
+		for _, m := range s.Within(line[0], corridorMeters) {
+			result = append(result, CorridorMatch[T]{SpatialMatch: m, Snapped: line[0]})
+		}
+		return result
+	}
+
+	best := map[int]*CorridorMatch[T]{}
+	along := 0.0
+	for i := 0; i+1 < len(line); i++ {
+		a, b := line[i], line[i+1]
+		segmentLength := haversineMeters(a, b)
+		lonDeg, latDeg := metersToDegrees(corridorMeters, math.Max(math.Abs(a[1]), math.Abs(b[1])))
+		s.candidates(
+			math.Min(a[0], b[0])-lonDeg, math.Min(a[1], b[1])-latDeg,
+			math.Max(a[0], b[0])+lonDeg, math.Max(a[1], b[1])+latDeg,
+			func(j int) {
+				item := s.items[j]
+				d, t := pointSegmentDistanceMeters(item.coordinate, a, b)
+				if d > corridorMeters {
+					return
+				}
+				if current, ok := best[j]; ok && current.Distance <= d {
+					return
+				}
+				best[j] = &CorridorMatch[T]{
+					SpatialMatch:       SpatialMatch[T]{Value: item.value, Coordinate: item.coordinate, Distance: d},
+					Segment:            i,
+					Snapped:            pointOnSegment(a, b, t),
+					DistanceAlongRoute: along + t*segmentLength,
+				}
+			})
+		along += segmentLength
+	}
+
+	indexes := make([]int, 0, len(best))
+	for j := range best {
+		indexes = append(indexes, j)
+	}
+	// 同じ位置の場合は索引した順にする
+	sort.Ints(indexes)
+	for _, j := range indexes {
+		result = append(result, *best[j])
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].DistanceAlongRoute < result[j].DistanceAlongRoute })
+	return result
+}
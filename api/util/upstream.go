@@ -0,0 +1,267 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"template-mobile-app-api/httpclient"
+)
+
+var (
+	// ErrUpstreamQuotaExceeded はリクエスト予算を使い切り、待ち行列にも入れない場合のエラー (429)
+	ErrUpstreamQuotaExceeded = errors.New("upstream request quota exceeded")
+	// ErrUpstreamUnavailable は待機中に期限切れ・キャンセルとなった場合のエラー (503)
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+)
+
+// UpstreamError は外部APIの予算管理で発生したエラー
+type UpstreamError struct {
+	Upstream string
+	Err      error
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Upstream, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatusError は外部APIが200以外を返した場合のエラー
+type HTTPStatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("upstream returned status %d", e.StatusCode)
+}
+
+// Upstream は外部APIごとのトークンバケットと同一リクエストの合流を管理する
+//
+// トークンが無い場合は予約して順番待ちする。待ち行列が一杯、または
+// 待ち時間が maxWait / context の期限を超える場合は待たずに ErrUpstreamQuotaExceeded を返す。
+type Upstream struct {
+	Name string
+
+	rate     float64       // 1秒あたりに補充されるトークン数
+	burst    float64       // バケット容量
+	maxQueue int           // 順番待ちできるリクエスト数
+	maxWait  time.Duration // 順番待ちの上限時間
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	queued int
+
+	group singleflight.Group
+}
+
+// NewUpstream は per あたり requests 回まで許可する Upstream を作成する
+func NewUpstream(name string, requests int, per time.Duration, maxQueue int, maxWait time.Duration) *Upstream {
+	if requests < 1 {
+		requests = 1
+	}
+	return &Upstream{
+		Name:     name,
+		rate:     float64(requests) / per.Seconds(),
+		burst:    float64(requests),
+		maxQueue: maxQueue,
+		maxWait:  maxWait,
+		tokens:   float64(requests),
+		last:     time.Now(),
+	}
+}
+
+// refill は経過時間分のトークンを補充する (mu を保持して呼ぶこと)
+func (u *Upstream) refill(now time.Time) {
+	elapsed := now.Sub(u.last).Seconds()
+	if elapsed > 0 {
+		u.tokens = math.Min(u.burst, u.tokens+elapsed*u.rate)
+		u.last = now
+	}
+}
+
+// waitDuration はトークンが1つ得られるまでの時間 (mu を保持して呼ぶこと)
+func (u *Upstream) waitDuration() time.Duration {
+	if u.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - u.tokens) / u.rate * float64(time.Second))
+}
+
+// RetryAfter は次にリクエストを送れるまでのおおよその時間を返す
+func (u *Upstream) RetryAfter() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.refill(time.Now())
+	return u.waitDuration()
+}
+
+// Wait はトークンを1つ取得するまで待機する
+func (u *Upstream) Wait(ctx context.Context) error {
+	u.mu.Lock()
+	now := time.Now()
+	u.refill(now)
+	if u.tokens >= 1 {
+		u.tokens--
+		u.mu.Unlock()
+		return nil
+	}
+
+	wait := u.waitDuration()
+	deadline, hasDeadline := ctx.Deadline()
+	if u.queued >= u.maxQueue || wait > u.maxWait || (hasDeadline && now.Add(wait).After(deadline)) {
+		u.mu.Unlock()
+		return &UpstreamError{Upstream: u.Name, Err: ErrUpstreamQuotaExceeded}
+	}
+	// 先にトークンを予約しておき、後続のリクエストはその分だけ長く待つ
+	u.tokens--
+	u.queued++
+	u.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		u.mu.Lock()
+		u.queued--
+		u.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		u.mu.Lock()
+		u.queued--
+		u.tokens++
+		u.mu.Unlock()
+		return &UpstreamError{Upstream: u.Name, Err: fmt.Errorf("%w: %v", ErrUpstreamUnavailable, ctx.Err())}
+	}
+}
+
+// Do はトークンを取得してから fn を実行する
+//
+// 同じ key のリクエストが実行中であれば新たに送信せず、その結果を共有する。
+// 結果はバイト列で共有するため、呼び出し側はそれぞれデコードすること。
+// 共有する呼び出しは最初の呼び出し元がキャンセルしても止めず、UPSTREAM_CALL_TIMEOUT (デフォルト30秒) で打ち切る。
+// 各呼び出し元は自分の ctx が終われば待つのをやめる。
+func (u *Upstream) Do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	ch := u.group.DoChan(key, func() (any, error) {
+		shared, cancel := context.WithTimeout(context.WithoutCancel(ctx), getEnvDuration("UPSTREAM_CALL_TIMEOUT", 30*time.Second))
+		defer cancel()
+		if err := u.Wait(shared); err != nil {
+			return nil, err
+		}
+		return fn(shared)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, &UpstreamError{Upstream: u.Name, Err: fmt.Errorf("%w: %v", ErrUpstreamUnavailable, ctx.Err())}
+	}
+}
+
+// UpstreamStatus は外部API呼び出しのエラーをレスポンスのステータスコードに変換する
+func UpstreamStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUpstreamQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrUpstreamUnavailable), errors.Is(err, httpclient.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// RetryAfterSeconds は Retry-After ヘッダ用の秒数 (最低1秒)
+func RetryAfterSeconds(u *Upstream) string {
+	return strconv.Itoa(int(math.Ceil(math.Max(1, u.RetryAfter().Seconds()))))
+}
+
+// =================外部APIごとの予算=================
+// .env の読み込み後に環境変数を評価するため初回利用時に生成する
+
+var (
+	orsUpstreamOnce       sync.Once
+	orsUpstream           *Upstream
+	nominatimUpstreamOnce sync.Once
+	nominatimUpstream     *Upstream
+
+	selfHostedNominatimUpstreamOnce sync.Once
+	selfHostedNominatimUpstream     *Upstream
+	gsiUpstreamOnce                 sync.Once
+	gsiUpstream                     *Upstream
+)
+
+// ORSUpstream は OpenRouteService 用の予算 (無料キーは 40 req/min)
+func ORSUpstream() *Upstream {
+	orsUpstreamOnce.Do(func() {
+		orsUpstream = NewUpstream("openrouteservice",
+			getEnvInt("ORS_REQUESTS_PER_MINUTE", 40), time.Minute,
+			getEnvInt("ORS_MAX_QUEUE", 20),
+			getEnvDuration("ORS_MAX_QUEUE_WAIT", 10*time.Second))
+	})
+	return orsUpstream
+}
+
+// NominatimUpstream は Nominatim 用の予算 (利用規約上 1 req/sec)
+func NominatimUpstream() *Upstream {
+	nominatimUpstreamOnce.Do(func() {
+		nominatimUpstream = NewUpstream("nominatim",
+			getEnvInt("NOMINATIM_REQUESTS_PER_SECOND", 1), time.Second,
+			getEnvInt("NOMINATIM_MAX_QUEUE", 10),
+			getEnvDuration("NOMINATIM_MAX_QUEUE_WAIT", 5*time.Second))
+	})
+	return nominatimUpstream
+}
+
+// SelfHostedNominatimUpstream は自前で立てた Nominatim 用の予算 (利用規約の制限は無いがサーバー保護のため)
+func SelfHostedNominatimUpstream() *Upstream {
+	selfHostedNominatimUpstreamOnce.Do(func() {
+		selfHostedNominatimUpstream = NewUpstream("nominatim_self_hosted",
+			getEnvInt("NOMINATIM_SELF_HOSTED_REQUESTS_PER_SECOND", 10), time.Second,
+			getEnvInt("NOMINATIM_SELF_HOSTED_MAX_QUEUE", 50),
+			getEnvDuration("NOMINATIM_SELF_HOSTED_MAX_QUEUE_WAIT", 5*time.Second))
+	})
+	return selfHostedNominatimUpstream
+}
+
+// GSIUpstream は国土地理院 住所検索API用の予算
+func GSIUpstream() *Upstream {
+	gsiUpstreamOnce.Do(func() {
+		gsiUpstream = NewUpstream("gsi",
+			getEnvInt("GSI_REQUESTS_PER_SECOND", 2), time.Second,
+			getEnvInt("GSI_MAX_QUEUE", 10),
+			getEnvDuration("GSI_MAX_QUEUE_WAIT", 5*time.Second))
+	})
+	return gsiUpstream
+}
+
+func getEnvInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
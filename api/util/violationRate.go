@@ -1,12 +1,8 @@
 package util
 
 import (
+	"fmt"
 	"math"
-	"math/rand"
-	"time"
-
-	"encoding/json"
-	"os"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,16 +14,28 @@ type ViolationRate struct {
 	ViolationCount int       `json:"violation_count,omitempty"` //違反件数
 	Coordinate     []float64 `json:"coordinate"`
 	Message        string    `json:"message,omitempty"`
+	Place          string    `json:"place,omitempty"` //交差点名または周辺の地名 (逆ジオコーディング)
+}
+
+// RouteViolationRate は経路沿いの違反率の交差点
+// Coordinate は交差点の座標のままにし、経路上の位置は SnappedCoordinate に入れる
+type RouteViolationRate struct {
+	ViolationRate
+	SnappedCoordinate  []float64 `json:"snapped_coordinate"`   // 経路上で交差点に最も近い位置 [経度, 緯度]
+	DistanceAlongRoute float64   `json:"distance_along_route"` // 出発地から SnappedCoordinate までの経路上の距離(m)
+	DistanceFromRoute  float64   `json:"distance_from_route"`  // 経路からの距離(m)
 }
 
 // GetViolationRates godoc
 // @Summary 違反箇所の交差点
-// @Description /directions/bicycleでの経路検索結果に対する違反箇所の交差点を返す
+// @Description /directions/bicycleでの経路検索結果に対する違反箇所の交差点を返す。見出し (name) とメッセージは Accept-Language (または lang) の言語で返す
 // @Param session_id query string false "/directions/bicycleのレスポンス内のsession_id" example(session_id=53238c22-12ac-8ff8-fcdd-738d30a780df)
+// @Param lang query string false "メッセージの言語 (Accept-Language より優先)" example(en)
+// @Param Accept-Language header string false "メッセージの言語 (ja, en。デフォルト ja)"
 // @Tags map
 // @Accept json
 // @Produce json
-// @Success 200 {object} map[string][]ViolationRate "List of violation rates for given coordinates"
+// @Success 200 {object} map[string][]RouteViolationRate "List of violation rates for given coordinates"
 // @Router /violation_rates [get]
 func GetViolationRates(c *gin.Context) {
 	session_id := c.Query("session_id")
@@ -55,73 +63,64 @@ func GetViolationRates(c *gin.Context) {
 	// 	},
 	// }
 
-	featureORSGeometry := SessionIDResponse[session_id]
-	filteredRates := FilterViolationRates(featureORSGeometry, violationRates)
+	featureORSGeometry, _ := SessionGeometry(session_id)
+	filteredRates := FilterViolationRates(featureORSGeometry, MessageLanguage(c))
 
 	c.JSON(200, gin.H{
 		"violation_rates": filteredRates,
 	})
 }
 
-var warningMessages = []string{
-	"歩道ではなく車道を走行しましょう。",
-	"歩道走行は禁止されています。安全な車道を走りましょう。",
-	"歩行者を守るため、歩道では走らないでください。",
-	"自転車は原則、車道を通行します。",
-	"歩道では降りて押して歩きましょう。",
-}
-
-// 東京付近の1mを度に換算（経度方向のみ簡易）
-const metersPerDegree = 111319.0 // 赤道での1° ≈ 111.3 km
-
-// 距離の閾値（20mを度換算）
-var threshold float64 = 20.0 / metersPerDegree
-
-// 距離（度ベース）を計算（単純なユークリッド距離）
-func distance(coord1, coord2 []float64) float64 {
-	dLon := coord1[0] - coord2[0]
-	dLat := coord1[1] - coord2[1]
-	return math.Sqrt(dLon*dLon + dLat*dLat)
-}
-
-func FilterViolationRates(geometry ORSGeometry, violations []ViolationRate) []ViolationRate {
-	var result []ViolationRate
-	for _, v := range violations {
-		for _, c := range geometry.Coordinates {
-			if distance(v.Coordinate, c) < threshold {
-				var title string
-				if v.ViolationRate < 0.3 { // 低リスク
-					title = "注意 交差点"
-				} else if v.ViolationRate < 0.8 { // 中リスク
-					title = "警告 交差点"
-				} else { // 高リスク
-					title = "違反多発 交差点"
-				}
-				rand.Seed(time.Now().UnixNano())
-				violationRate := ViolationRate{
-					Type:           "intersection",
-					Name:           title,
-					ViolationRate:  math.Floor(v.ViolationRate*100) / 100, // 小数点以下2桁に丸める
-					ViolationCount: v.ViolationCount,
-					Coordinate:     c,
-					Message:        warningMessages[rand.Intn(len(warningMessages))]}
+// 経路から違反率の交差点を探す距離(m)
+const violationCorridorMeters = 20.0
 
-				result = append(result, violationRate)
-				break
-			}
+// FilterViolationRates は経路の線分から violationCorridorMeters 以内にある違反率の交差点を、経路の始点から近い順に返す
+// 距離は頂点ではなく線分への射影までの大円距離で測る
+// 見出しとメッセージは違反率の危険度 (0.3 未満 low, 0.8 未満 medium, それ以上 high) の violation_rate のテンプレートから lang で作る
+func FilterViolationRates(geometry ORSGeometry, lang string) []RouteViolationRate {
+	var result []RouteViolationRate
+	catalog := hazardMessages.get()
+	for _, m := range ViolationRateIndex().AlongPolyline(geometry.Coordinates, violationCorridorMeters) {
+		v := m.Value
+		place := v.Name
+		if place == "" {
+			place = HazardName(v.Coordinate)
 		}
+		rate := math.Floor(v.ViolationRate*100) / 100 // 小数点以下2桁に丸める
+		tier := riskTier(v.ViolationRate, 0.3, 0.8)
+		values := map[string]string{
+			"rate":  fmt.Sprintf("%.0f", rate*100),
+			"count": countValue(v.ViolationCount),
+			"place": place,
+		}
+		violationRate := ViolationRate{
+			Type:           "intersection",
+			Name:           catalog.title(lang, "violation_rate", tier, values),
+			ViolationRate:  rate,
+			ViolationCount: v.ViolationCount,
+			Coordinate:     v.Coordinate,
+			Message:        catalog.message(lang, "violation_rate", tier, hazardMessageKey(v.Coordinate), values),
+			Place:          place}
+
+		result = append(result, RouteViolationRate{
+			ViolationRate:      violationRate,
+			SnappedCoordinate:  m.Snapped,
+			DistanceAlongRoute: math.Round(m.DistanceAlongRoute),
+			DistanceFromRoute:  math.Round(m.Distance),
+		})
 	}
 	return result
 }
 
-func LoadViolationRates(filePath string) ([]ViolationRate, error) {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		panic(err)
-	}
-	var violations []ViolationRate
-	if err := json.Unmarshal(data, &violations); err != nil {
-		panic(err)
+// violationRates は違反率の交差点 (VIOLATION_RATES_FILE, デフォルト data/violation_rates.json)
+var violationRates = newDataset("violation_rates", "VIOLATION_RATES_FILE", "data/violation_rates.json", parseJSONDataset(func(v ViolationRate) error {
+	if v.ViolationRate < 0 || math.IsNaN(v.ViolationRate) {
+		return fmt.Errorf("violation_rate must not be negative: %v", v.ViolationRate)
 	}
-	return violations, nil
+	return validateCoordinate(v.Coordinate)
+}))
+
+// ViolationRates は読み込み済みの違反率の交差点を返す
+func ViolationRates() []ViolationRate {
+	return violationRates.Get()
 }
@@ -1,32 +1,308 @@
 package util
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 // XXX カスタム構造体
 type WarningPoint struct {
-	Type       string    `json:"type"`       //交差点なのか直線道路なのか(このフィールドいらない)
-	Name       string    `json:"name"`       //名称, 地元での言われ名など(地獄谷etc)
-	Coordinate []float64 `json:"coordinate"` //座標
-	Message    string    `json:"message"`    //警告のメッセージ(どんな事故が多かったか)
+	Type       string         `json:"type"`               //交差点なのか直線道路なのか(このフィールドいらない)
+	Name       string         `json:"name"`               //名称, 地元での言われ名など(地獄谷etc)
+	Coordinate []float64      `json:"coordinate"`         //座標
+	Message    string         `json:"message"`            //警告のメッセージ(どんな事故が多かったか)
+	Ward       string         `json:"ward,omitempty"`     //区市町村 (/warning_point で名前から求める)
+	Accident   *AccidentStats `json:"accident,omitempty"` //事故の内訳 (accident_hotspot のみ)
+	Report     *HazardReport  `json:"report,omitempty"`   //利用者の報告 (user_report のみ)
 }
 
-var WorningIntersectionPoints []WarningPoint
+// warningIntersections は worningIntersection で作る取締強化交差点 (WARNING_INTERSECTIONS_FILE, デフォルト warningIntersection.json)
+// 座標が求まらなかった交差点は coordinate が空になる
+var warningIntersections = newDataset("warning_intersections", "WARNING_INTERSECTIONS_FILE", "warningIntersection.json", parseJSONDataset(func(w WarningPoint) error {
+	if len(w.Coordinate) == 0 {
+		return nil
+	}
+	return validateCoordinate(w.Coordinate)
+}))
+
+// WarningIntersections は読み込み済みの取締強化交差点を返す
+func WarningIntersections() []WarningPoint {
+	return warningIntersections.Get()
+}
+
+var warningPoints = newDerived(func() []WarningPoint {
+	points := append([]WarningPoint{}, WarningIntersections()...)
+	for _, h := range AccidentHotspots() {
+		points = append(points, h.WarningPoint())
+	}
+	return points
+})
+
+// WarningPoints は取締強化交差点と事故多発地点をあわせた注意点を返す
+func WarningPoints() []WarningPoint {
+	return warningPoints.get()
+}
+
+// warningPointCorridorMeters は経路沿いの注意点を探す距離 (WARNING_POINT_ROUTE_DISTANCE, m, デフォルト30)
+// /warning_point?session_id= と経路検索の warning_points で同じ値を使う
+func warningPointCorridorMeters() float64 {
+	return float64(getEnvInt("WARNING_POINT_ROUTE_DISTANCE", 30))
+}
+
+const (
+	// type が空の注意点の種類 (取締強化交差点)
+	defaultWarningPointType = "intersection"
+	maxWarningPointLimit    = 1000
+)
+
+// WarningPointFilter は /warning_point の絞り込み条件
+type WarningPointFilter struct {
+	SessionID string      // 経路沿い (経路の始点から近い順)
+	Center    *Coordinate // 中心からの距離 (近い順)
+	Radius    float64     // Center からの距離(m)
+	BBox      *[4]float64 // 範囲 (西, 南, 東, 北)
+	Wards     []string    // 区市町村 (千代田区)
+	Reason    string      // メッセージ (取締理由) に含まれる文字列
+	Types     []string    // 注意点の種類
+	Limit     int         // 件数 (0 なら全て)
+	Offset    int
+}
+
+// GeoJSONFeatureCollection は GeoJSON の FeatureCollection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type" example:"FeatureCollection"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature は点の GeoJSON Feature
+type GeoJSONFeature struct {
+	Type       string         `json:"type" example:"Feature"`
+	Geometry   GeoJSONPoint   `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// GeoJSONPoint は GeoJSON の Point
+type GeoJSONPoint struct {
+	Type        string    `json:"type" example:"Point"`
+	Coordinates []float64 `json:"coordinates"` // [経度, 緯度]
+}
 
 // GetWarningPoints godoc
 // @Summary 注意点取得
-// @Description 取締強化交差点などの注意点をまとめて返す。
+// @Description 取締強化交差点・自転車事故の多発地点 (type=accident_hotspot、事故の内訳を accident に入れる)・承認済みの利用者の報告 (type=user_report、報告を report に入れる) などの注意点を返す。session_id を指定すると経路沿いの注意点を経路の始点から近い順に、lat, lon, radius を指定するとその範囲内の注意点を近い順に返す。bbox・ward・reason・type で絞り込み、limit・offset で分割して取得できる (全件数は X-Total-Count ヘッダー)。format=geojson で GeoJSON の FeatureCollection を返す。メッセージは Accept-Language (または lang) の言語で返す
 // @Tags map
 // @Accept json
 // @Produce json
+// @Param session_id query string false "/directions/bicycleのレスポンス内のsession_id"
+// @Param lat query number false "中心の緯度"
+// @Param lon query number false "中心の経度"
+// @Param radius query number false "中心からの距離(m, デフォルト1000)"
+// @Param bbox query string false "範囲 (西,南,東,北)" example(139.73,35.66,139.78,35.70)
+// @Param ward query string false "区市町村 (カンマ区切り)" example(千代田区)
+// @Param reason query string false "メッセージ (取締理由) に含まれる文字列" example(事故多発)
+// @Param type query string false "注意点の種類 (intersection, accident_hotspot, user_report のカンマ区切り)" example(intersection)
+// @Param limit query int false "件数 (1-1000, デフォルトは全件)"
+// @Param offset query int false "読み飛ばす件数"
+// @Param format query string false "json (デフォルト) または geojson"
+// @Param lang query string false "メッセージの言語 (Accept-Language より優先)" example(en)
+// @Param Accept-Language header string false "メッセージの言語 (ja, en。デフォルト ja)"
 // @Success 200 {object} []WarningPoint "注意地点情報"
+// @Failure 400 {object} ErrorResponse "パラメータ不正"
+// @Failure 404 {object} ErrorResponse "session_id のルートが無い"
 // @Router /warning_point [get]
 func GetWarningPoints(c *gin.Context) {
-	//ここで結合する
-	warningPoints := WorningIntersectionPoints
+	filter, err := parseWarningPointFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid parameter", Message: err.Error()})
+		return
+	}
+	geojson := false
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+	case "geojson":
+		geojson = true
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid parameter", Message: "format must be json or geojson"})
+		return
+	}
 
+	warningPoints, total, err := FilterWarningPoints(filter)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Session not found", Message: err.Error()})
+		return
+	}
+	warningPoints = LocalizeWarningPoints(warningPoints, MessageLanguage(c))
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if geojson {
+		c.JSON(http.StatusOK, WarningPointsGeoJSON(warningPoints))
+		return
+	}
 	c.JSON(http.StatusOK, warningPoints)
 }
+
+// parseWarningPointFilter はクエリパラメータから絞り込み条件を作る
+func parseWarningPointFilter(c *gin.Context) (WarningPointFilter, error) {
+	filter := WarningPointFilter{SessionID: c.Query("session_id"), Reason: c.Query("reason")}
+	if c.Query("lat") != "" || c.Query("lon") != "" {
+		center, err := ParseCoordinate(c.Query("lon") + "," + c.Query("lat"))
+		if err != nil {
+			return filter, err
+		}
+		filter.Center = &center
+		filter.Radius = 1000
+		if v := c.Query("radius"); v != "" {
+			r, err := strconv.ParseFloat(v, 64)
+			if err != nil || r <= 0 {
+				return filter, fmt.Errorf("radius must be a positive number")
+			}
+			filter.Radius = r
+		}
+	}
+	if v := c.Query("bbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return filter, fmt.Errorf("bbox must be west,south,east,north")
+		}
+		var box [4]float64
+		for i, part := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return filter, fmt.Errorf("bbox must be west,south,east,north")
+			}
+			box[i] = f
+		}
+		// 対角の2点の順番は問わない
+		box = [4]float64{min(box[0], box[2]), min(box[1], box[3]), max(box[0], box[2]), max(box[1], box[3])}
+		filter.BBox = &box
+	}
+	filter.Wards = splitList(c.Query("ward"))
+	filter.Types = splitList(c.Query("type"))
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxWarningPointLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxWarningPointLimit)
+		}
+		filter.Limit = n
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+		filter.Offset = n
+	}
+	return filter, nil
+}
+
+// withDefaults は空の種類・名前・区市町村を補った注意点を返す
+// 名前の無い地点には逆ジオコーディングで付けた名前を、区市町村は名前から求めて付ける
+func (p WarningPoint) withDefaults() WarningPoint {
+	if p.Type == "" {
+		p.Type = defaultWarningPointType
+	}
+	if p.Name == "" {
+		p.Name = HazardName(p.Coordinate)
+	}
+	if p.Ward == "" {
+		p.Ward = wardOf(p.Name)
+	}
+	return p
+}
+
+// FilterWarningPoints は条件に合う注意点と、分割する前の件数を返す
+func FilterWarningPoints(filter WarningPointFilter) ([]WarningPoint, int, error) {
+	var candidates []WarningPoint
+	index := WarningPointIndex()
+	switch {
+	case filter.SessionID != "":
+		geometry, ok := SessionGeometry(filter.SessionID)
+		if !ok {
+			return nil, 0, fmt.Errorf("no route for session_id: %s", filter.SessionID)
+		}
+		for _, m := range index.AlongPolyline(geometry.Coordinates, warningPointCorridorMeters()) {
+			candidates = append(candidates, m.Value)
+		}
+	case filter.Center != nil:
+		for _, m := range index.Within(filter.Center[:], filter.Radius) {
+			candidates = append(candidates, m.Value)
+		}
+	case filter.BBox != nil:
+		// 索引に登録した順 (元のデータの順) に並べる
+		for _, m := range index.InBounds(filter.BBox[0], filter.BBox[1], filter.BBox[2], filter.BBox[3]) {
+			candidates = append(candidates, m.Value)
+		}
+	default:
+		candidates = slices.Concat(WarningPoints(), ApprovedReportWarningPoints())
+	}
+
+	result := []WarningPoint{}
+	for _, p := range candidates {
+		p = p.withDefaults()
+		if filter.BBox != nil && (len(p.Coordinate) != 2 ||
+			p.Coordinate[0] < filter.BBox[0] || p.Coordinate[0] > filter.BBox[2] ||
+			p.Coordinate[1] < filter.BBox[1] || p.Coordinate[1] > filter.BBox[3]) {
+			continue
+		}
+		if len(filter.Wards) > 0 && !slices.Contains(filter.Wards, p.Ward) {
+			continue
+		}
+		if len(filter.Types) > 0 && !slices.Contains(filter.Types, p.Type) {
+			continue
+		}
+		if filter.Reason != "" && !strings.Contains(p.Message, filter.Reason) {
+			continue
+		}
+		result = append(result, p)
+	}
+
+	total := len(result)
+	result = result[min(filter.Offset, total):]
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, total, nil
+}
+
+// WarningPointsGeoJSON は注意点を GeoJSON の FeatureCollection にする (座標の無い地点は除く)
+func WarningPointsGeoJSON(points []WarningPoint) GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	for _, p := range points {
+		if len(p.Coordinate) != 2 {
+			continue
+		}
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: GeoJSONPoint{Type: "Point", Coordinates: p.Coordinate},
+			Properties: map[string]any{
+				"type":    p.Type,
+				"name":    p.Name,
+				"ward":    p.Ward,
+				"message": p.Message,
+			},
+		})
+		properties := collection.Features[len(collection.Features)-1].Properties
+		if p.Accident != nil {
+			properties["accident"] = p.Accident
+		}
+		if p.Report != nil {
+			properties["report"] = p.Report
+		}
+	}
+	return collection
+}
+
+// splitList はカンマ区切りの値を分ける
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,10 @@
+module prepare_accident
+
+go 1.24.5
+
+require (
+	golang.org/x/text v0.27.0
+	template-mobile-app-api v0.0.0
+)
+
+replace template-mobile-app-api => ../../api
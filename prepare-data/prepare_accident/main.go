@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/unicode/norm"
+
+	"template-mobile-app-api/provenance"
+)
+
+// AccidentStats は事故の件数の内訳
+type AccidentStats struct {
+	Count     int            `json:"count"`       // 事故件数
+	Fatal     int            `json:"fatal"`       // 死亡事故
+	Serious   int            `json:"serious"`     // 重傷事故
+	Minor     int            `json:"minor"`       // 軽傷事故
+	TimeOfDay map[string]int `json:"time_of_day"` // 時間帯別の件数 (morning, daytime, evening, night)
+	Years     []int          `json:"years,omitempty"`
+}
+
+// AccidentHotspot はAPIの注意点で使う自転車事故の多発地点
+type AccidentHotspot struct {
+	ID         string    `json:"id"`
+	Coordinate []float64 `json:"coordinate"` // [経度, 緯度] (事故地点の重心)
+	AccidentStats
+}
+
+// Accident は事故1件
+type Accident struct {
+	Lat, Lon float64
+	Severity string // fatal, serious, minor
+	Hour     int    // 発生時 (不明なら -1)
+	Year     int
+}
+
+// 時間帯 (発生時で分ける)
+const (
+	timeMorning = "morning" // 6-10時
+	timeDaytime = "daytime" // 10-16時
+	timeEvening = "evening" // 16-20時
+	timeNight   = "night"   // 20-6時
+)
+
+// 交通事故統計情報 (本票) の列名
+// 列名の表記 (全角の括弧・空白) が年によって揺れるため、NFKC で揃えて空白を除いてから前方一致で探す
+var (
+	colPref      = "都道府県コード"
+	colContent   = "事故内容" // 1: 死亡, 2: 負傷
+	colYear      = "発生日時年"
+	colHour      = "発生日時時"
+	colLat       = "地点緯度"
+	colLon       = "地点経度"
+	colPartyType = "当事者種別"  // 当事者A・B の両方
+	colInjury    = "人身損傷程度" // 1: 死亡, 2: 重傷, 3: 軽傷, 4: 損傷なし
+)
+
+type columns struct {
+	pref, content, year, hour, lat, lon int
+	partyTypes, injuries                []int
+}
+
+func main() {
+	outdir := flag.String("outdir", ".", "Output dir")
+	// 警視庁は 30 (空にすると全国)
+	pref := flag.String("pref", "30", "Prefecture (police) code to keep")
+	// コード表の当事者種別「軽車両 自転車」「駆動補助機付自転車」
+	bicycleCodes := flag.String("bicycle-codes", "51,52", "Party type codes treated as bicycles (comma separated)")
+	radius := flag.Float64("radius", 50, "Cluster radius (m)")
+	minCount := flag.Int("min", 3, "Minimum accidents per hotspot")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: go run . [-outdir dir] [-pref 30] [-radius 50] [-min 3] <honhyo.csv>...")
+		os.Exit(1)
+	}
+
+	codes := map[string]bool{}
+	for _, code := range strings.Split(*bicycleCodes, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			codes[code] = true
+		}
+	}
+
+	var accidents []Accident
+	var fetchedAt time.Time // 一番新しいCSVの更新日時 (ダウンロードした日時)
+	for _, path := range flag.Args() {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(fetchedAt) {
+			fetchedAt = info.ModTime()
+		}
+		fmt.Printf("Processing file: %s\n", path)
+		loaded, total, err := readAccidents(path, *pref, codes)
+		if err != nil {
+			log.Fatalf("読み込みエラー: %v", err)
+		}
+		fmt.Printf("  -> %d 件中、自転車が関係する事故 %d 件\n", total, len(loaded))
+		accidents = append(accidents, loaded...)
+	}
+
+	hotspots := clusterAccidents(accidents, *radius, *minCount)
+	fmt.Printf("事故多発地点: %d か所 (半径%.0fm以内に%d件以上)\n", len(hotspots), *radius, *minCount)
+
+	outputFile := filepath.Join(*outdir, "accident_hotspots.json")
+	writeJSON(outputFile, hotspots)
+	fmt.Printf("事故多発地点データを %s に出力しました\n", outputFile)
+
+	// 出典をサイドカーに書く
+	if err := provenance.Write(outputFile, provenance.Metadata{
+		Dataset:   filepath.Base(outputFile),
+		SourceURL: provenance.NPATrafficAccidents.URL,
+		Sources:   []provenance.Source{provenance.NPATrafficAccidents},
+		FetchedAt: fetchedAt,
+		Records:   len(hotspots),
+	}); err != nil {
+		log.Fatalf("出典の書き込みエラー: %v", err)
+	}
+}
+
+// readAccidents は本票のCSVから自転車が関係する事故を読み込み、全件数とあわせて返す
+// 文字コードは UTF-8 でなければ Shift_JIS として読む
+func readAccidents(path, pref string, bicycleCodes map[string]bool) ([]Accident, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !utf8.Valid(data) {
+		if data, err = japanese.ShiftJIS.NewDecoder().Bytes(data); err != nil {
+			return nil, 0, fmt.Errorf("%s: Shift_JIS の変換に失敗: %v", path, err)
+		}
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: 見出しの読み込みに失敗: %v", path, err)
+	}
+	cols, err := findColumns(header)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var accidents []Accident
+	total := 0
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		total++
+		if pref != "" && cols.pref >= 0 && strings.TrimSpace(field(record, cols.pref)) != pref {
+			continue
+		}
+		bicycle := false
+		for _, i := range cols.partyTypes {
+			if bicycleCodes[strings.TrimSpace(field(record, i))] {
+				bicycle = true
+			}
+		}
+		if !bicycle {
+			continue
+		}
+		lat, errLat := parseDegrees(field(record, cols.lat))
+		lon, errLon := parseDegrees(field(record, cols.lon))
+		if errLat != nil || errLon != nil || lat == 0 || lon == 0 {
+			continue
+		}
+		accident := Accident{Lat: lat, Lon: lon, Severity: severity(record, cols), Hour: -1}
+		if h, err := strconv.Atoi(strings.TrimSpace(field(record, cols.hour))); err == nil && h >= 0 && h < 24 {
+			accident.Hour = h
+		}
+		accident.Year, _ = strconv.Atoi(strings.TrimSpace(field(record, cols.year)))
+		accidents = append(accidents, accident)
+	}
+	return accidents, total, nil
+}
+
+// findColumns は見出しから使う列の位置を探す
+func findColumns(header []string) (columns, error) {
+	cols := columns{pref: -1, content: -1, year: -1, hour: -1, lat: -1, lon: -1}
+	for i, h := range header {
+		name := strings.Join(strings.Fields(norm.NFKC.String(h)), "")
+		switch {
+		case strings.HasPrefix(name, colPref):
+			cols.pref = i
+		case strings.HasPrefix(name, colContent):
+			cols.content = i
+		case strings.HasPrefix(name, colYear):
+			cols.year = i
+		case strings.HasPrefix(name, colHour):
+			cols.hour = i
+		case strings.HasPrefix(name, colLat):
+			cols.lat = i
+		case strings.HasPrefix(name, colLon):
+			cols.lon = i
+		case strings.HasPrefix(name, colPartyType):
+			cols.partyTypes = append(cols.partyTypes, i)
+		case strings.HasPrefix(name, colInjury):
+			cols.injuries = append(cols.injuries, i)
+		}
+	}
+	if cols.lat < 0 || cols.lon < 0 {
+		return cols, fmt.Errorf("緯度・経度の列が見つかりません")
+	}
+	if len(cols.partyTypes) == 0 {
+		return cols, fmt.Errorf("当事者種別の列が見つかりません")
+	}
+	return cols, nil
+}
+
+func field(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
+// parseDegrees は本票の度分秒 (緯度 DDMMSSsss, 経度 DDDMMSSsss) または10進数の度を度にする
+func parseDegrees(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ".") {
+		return strconv.ParseFloat(s, 64)
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	degrees := v / 10000000
+	minutes := v / 100000 % 100
+	seconds := float64(v%100000) / 1000
+	return float64(degrees) + float64(minutes)/60 + seconds/3600, nil
+}
+
+// severity は事故の程度を返す
+// 事故内容が死亡なら fatal、当事者に重傷者がいれば serious、それ以外は minor
+func severity(record []string, cols columns) string {
+	if strings.TrimSpace(field(record, cols.content)) == "1" {
+		return "fatal"
+	}
+	for _, i := range cols.injuries {
+		switch strings.TrimSpace(field(record, i)) {
+		case "1":
+			return "fatal"
+		case "2":
+			return "serious"
+		}
+	}
+	return "minor"
+}
+
+// timeOfDay は発生時の時間帯を返す
+func timeOfDay(hour int) string {
+	switch {
+	case hour >= 6 && hour < 10:
+		return timeMorning
+	case hour >= 10 && hour < 16:
+		return timeDaytime
+	case hour >= 16 && hour < 20:
+		return timeEvening
+	}
+	return timeNight
+}
+
+// 緯度1度あたりの距離(m)
+const meterPerLatDegree = 111320.0
+
+// clusterAccidents は半径 radius 以内の事故をまとめ、minCount 件以上の地点を件数の多い順に返す
+//
+// 周りの事故が多い事故から順に中心にし、まだどこにも属していない半径内の事故をその地点に入れる。
+// 地点の座標は属する事故の重心。
+func clusterAccidents(accidents []Accident, radius float64, minCount int) []AccidentHotspot {
+	if len(accidents) == 0 {
+		return []AccidentHotspot{}
+	}
+	// 半径の大きさのグリッドで近くの事故を探す
+	// 経度1度の距離は緯度が高いほど短いので、全国の場合も隣のセルまでで半径を覆えるよう最も高い緯度で経度の幅を決める
+	maxLat := 0.0
+	for _, a := range accidents {
+		maxLat = math.Max(maxLat, math.Abs(a.Lat))
+	}
+	cellLat := radius / meterPerLatDegree
+	cellLon := radius / (meterPerLatDegree * math.Cos(math.Min(maxLat, 89)*math.Pi/180))
+	type cell struct{ x, y int }
+	cellOf := func(a Accident) cell {
+		return cell{int(math.Floor(a.Lon / cellLon)), int(math.Floor(a.Lat / cellLat))}
+	}
+	grid := map[cell][]int{}
+	for i, a := range accidents {
+		c := cellOf(a)
+		grid[c] = append(grid[c], i)
+	}
+	neighbors := func(i int) []int {
+		var result []int
+		c := cellOf(accidents[i])
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, j := range grid[cell{c.x + dx, c.y + dy}] {
+					if distanceMeters(accidents[i], accidents[j]) <= radius {
+						result = append(result, j)
+					}
+				}
+			}
+		}
+		return result
+	}
+
+	// 周りの事故の数が多い順 (同じなら座標の順) に中心の候補にする
+	order := make([]int, len(accidents))
+	density := make([]int, len(accidents))
+	for i := range accidents {
+		order[i] = i
+		density[i] = len(neighbors(i))
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if density[i] != density[j] {
+			return density[i] > density[j]
+		}
+		if accidents[i].Lat != accidents[j].Lat {
+			return accidents[i].Lat < accidents[j].Lat
+		}
+		return accidents[i].Lon < accidents[j].Lon
+	})
+
+	assigned := make([]bool, len(accidents))
+	var hotspots []AccidentHotspot
+	for _, i := range order {
+		if assigned[i] || density[i] < minCount {
+			continue
+		}
+		var members []int
+		for _, j := range neighbors(i) {
+			if !assigned[j] {
+				members = append(members, j)
+			}
+		}
+		if len(members) < minCount {
+			continue
+		}
+		for _, j := range members {
+			assigned[j] = true
+		}
+		hotspots = append(hotspots, summarize(accidents, members))
+	}
+
+	sort.SliceStable(hotspots, func(a, b int) bool {
+		if hotspots[a].Count != hotspots[b].Count {
+			return hotspots[a].Count > hotspots[b].Count
+		}
+		return hotspots[a].Fatal+hotspots[a].Serious > hotspots[b].Fatal+hotspots[b].Serious
+	})
+	for i := range hotspots {
+		hotspots[i].ID = fmt.Sprintf("accident.%d", i+1)
+	}
+	return hotspots
+}
+
+// summarize は事故の一覧を1つの地点にまとめる
+func summarize(accidents []Accident, members []int) AccidentHotspot {
+	h := AccidentHotspot{AccidentStats: AccidentStats{TimeOfDay: map[string]int{}}}
+	var lat, lon float64
+	years := map[int]bool{}
+	for _, j := range members {
+		a := accidents[j]
+		lat += a.Lat
+		lon += a.Lon
+		h.Count++
+		switch a.Severity {
+		case "fatal":
+			h.Fatal++
+		case "serious":
+			h.Serious++
+		default:
+			h.Minor++
+		}
+		if a.Hour >= 0 {
+			h.TimeOfDay[timeOfDay(a.Hour)]++
+		}
+		if a.Year > 0 && !years[a.Year] {
+			years[a.Year] = true
+			h.Years = append(h.Years, a.Year)
+		}
+	}
+	sort.Ints(h.Years)
+	n := float64(len(members))
+	// 小数点以下6桁 (約10cm) に丸める
+	h.Coordinate = []float64{math.Round(lon/n*1e6) / 1e6, math.Round(lat/n*1e6) / 1e6}
+	return h
+}
+
+// distanceMeters は2つの事故の距離 (東京付近では平面の近似で十分)
+func distanceMeters(a, b Accident) float64 {
+	dy := (a.Lat - b.Lat) * meterPerLatDegree
+	dx := (a.Lon - b.Lon) * meterPerLatDegree * math.Cos((a.Lat+b.Lat)/2*math.Pi/180)
+	return math.Hypot(dx, dy)
+}
+
+func writeJSON(path string, v any) {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("ファイル作成エラー: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatalf("JSON書き込みエラー: %v", err)
+	}
+}
@@ -1,3 +1,7 @@
 module prepare_busstop
 
 go 1.24.5
+
+require template-mobile-app-api v0.0.0
+
+replace template-mobile-app-api => ../../api
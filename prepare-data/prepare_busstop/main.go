@@ -9,11 +9,16 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
+
+	"template-mobile-app-api/provenance"
 )
 
 type BusStop struct {
 	ID        string      `json:"id"`
 	Name      string      `json:"name"`
+	Kana      string      `json:"kana,omitempty"`    // 読み (検索用)
+	NameEn    string      `json:"name_en,omitempty"` // 英語名 (ローマ字入力の検索用)
 	Latitude  float64     `json:"latitude"`
 	Longitude float64     `json:"longitude"`
 	Polygon   [][]float64 `json:"polygon,omitempty"`
@@ -105,6 +110,12 @@ func convertToBusStops(odpтData []ODPTResponse) []BusStop {
 			name = data.Title.En
 		}
 
+		// 読みを取得 (検索用)
+		kana := data.Title.JaHrkt
+		if kana == "" {
+			kana = data.ODPTKana
+		}
+
 		// 座標が有効かチェック
 		if data.GeoLat == 0 || data.GeoLong == 0 {
 			continue
@@ -116,6 +127,8 @@ func convertToBusStops(odpтData []ODPTResponse) []BusStop {
 		busStop := BusStop{
 			ID:        id,
 			Name:      name,
+			Kana:      kana,
+			NameEn:    data.Title.En,
 			Latitude:  data.GeoLat,
 			Longitude: data.GeoLong,
 			Polygon:   polygon,
@@ -134,6 +147,7 @@ func main() {
 	url := "https://api-public.odpt.org/api/v4/odpt:BusstopPole?odpt:operator=odpt.Operator:Toei"
 
 	fmt.Println("都営バスのバス停データを取得中...")
+	fetchedAt := time.Now()
 	odpтData, err := fetchBusStopData(url)
 	if err != nil {
 		log.Fatalf("データ取得エラー: %v", err)
@@ -147,7 +161,7 @@ func main() {
 	fmt.Printf("変換後のバス停数: %d\n", len(busStops))
 
 	// JSONファイルに出力
-	outputFile := filepath.Join(*outdir, "bus_stopss.json")
+	outputFile := filepath.Join(*outdir, "bus_stops.json")
 	file, err := os.Create(outputFile)
 	if err != nil {
 		log.Fatalf("ファイル作成エラー: %v", err)
@@ -160,8 +174,20 @@ func main() {
 		log.Fatalf("JSON書き込みエラー: %v", err)
 	}
 
+	file.Close()
 	fmt.Printf("バス停データを %s に出力しました\n", outputFile)
 
+	// 出典をサイドカーに書く
+	if err := provenance.Write(outputFile, provenance.Metadata{
+		Dataset:   filepath.Base(outputFile),
+		SourceURL: url,
+		Sources:   []provenance.Source{provenance.ODPTToei},
+		FetchedAt: fetchedAt,
+		Records:   len(busStops),
+	}); err != nil {
+		log.Fatalf("出典の書き込みエラー: %v", err)
+	}
+
 	// コンソールにも最初の3件を表示
 	fmt.Println("\n=== 最初の3件の詳細（プレビュー） ===")
 	for i, busStop := range busStops {
@@ -1,28 +1,25 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"time"
+
+	"template-mobile-app-api/jpnorm"
+	"template-mobile-app-api/osm"
+	"template-mobile-app-api/provenance"
 )
 
-// Helper function for min
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
+// 交通量統計表 (東京都オープンデータカタログサイト)
+const trafficCountDatasetURL = "https://catalog.data.metro.tokyo.lg.jp/dataset/t000022d0000000035"
 
 // Coordinate represents a latitude/longitude point
 type Coordinate struct {
@@ -55,135 +52,36 @@ type IntersectionResult struct {
 }
 
 // Client handles Overpass API requests
+// Requests go through the shared OSM client, which identifies the tool,
+// spaces requests out and caches responses on disk.
 type Client struct {
 	baseURL string
-	client  *http.Client
+	osm     *osm.Client
 }
 
 // NewClient creates a new Overpass API client
 func NewClient() *Client {
 	return &Client{
 		baseURL: "https://overpass-api.de/api/interpreter",
-		client: &http.Client{
-			Transport: &http.Transport{
-				DisableCompression: false, // Enable automatic decompression
-			},
-		},
+		osm:     osm.NewTool(60 * time.Second),
 	}
 }
 
 // GetRoadData fetches road data for a specific road name
 func (c *Client) GetRoadData(roadName string, bbox [4]float64) (*OverpassResponse, error) {
-	// Let's manually reconstruct the exact curl command data
-	// The original was: data=%5Bout%3Ajson%5D%5Btimeout%3A25%5D%3B%0Away%5B%22highway%22%5D%5B%22name%22%3D%22%E7%AC%AC%E4%B8%80%E4%BA%AC%E6%B5%9C%22%5D(35.66%2C139.74%2C35.67%2C139.77)%3B%0Aout+geom%3B%0A
-	// Let's decode this step by step:
-	// %5B = [, %3A = :, %3D = =, %22 = ", %0A = newline, %2C = comma, etc.
-
-	// The decoded query should be:
-	// [out:json][timeout:25];
-	// way["highway"]["name"="第一京浜"](35.66,139.74,35.67,139.77);
-	// out geom;
-	// (with actual newlines)
-
-	// But the curl shows out+geom in the URL encoding, let me check if that's literally what it sends
 	query := fmt.Sprintf("[out:json][timeout:25];\nway[\"highway\"][\"name\"=\"%s\"](%.2f,%.2f,%.2f,%.2f);\nout geom;\n",
 		roadName, bbox[0], bbox[1], bbox[2], bbox[3])
 
-	fmt.Printf("=== DEBUG: Overpass Query for '%s' ===\n", roadName)
-	fmt.Printf("Query:\n%s", query)
-
-	// Let's try to match the exact curl encoding
-	// Create form data like curl does
-	formData := url.Values{}
-	formData.Set("data", query)
-	requestBody := formData.Encode()
-
-	fmt.Printf("Request Body (url.Values): %s\n", requestBody)
-
-	req, err := http.NewRequest("POST", c.baseURL, bytes.NewBufferString(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set headers to match the working curl command
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:142.0) Gecko/20100101 Firefox/142.0")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Accept-Language", "ja,en-US;q=0.7,en;q=0.3")
-	// Remove Accept-Encoding to let Go handle compression automatically
-	req.Header.Set("Origin", "https://overpass-turbo.eu")
-	req.Header.Set("Referer", "https://overpass-turbo.eu/")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Site", "cross-site")
-
-	fmt.Printf("Making request to: %s\n", c.baseURL)
-
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	fmt.Printf("Response Status: %s\n", resp.Status)
-	fmt.Printf("Response Headers:\n")
-	for key, values := range resp.Header {
-		for _, value := range values {
-			fmt.Printf("  %s: %s\n", key, value)
-		}
-	}
-
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.osm.PostForm(context.Background(), c.baseURL, url.Values{"data": {query}})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	fmt.Printf("Response Body Length: %d bytes\n", len(body))
-	fmt.Printf("Response Content-Type: %s\n", resp.Header.Get("Content-Type"))
-	fmt.Printf("Response Content-Encoding: %s\n", resp.Header.Get("Content-Encoding"))
-
-	// Check first few bytes for debugging
-	if len(body) > 0 {
-		fmt.Printf("First 10 bytes (hex): ")
-		for i := 0; i < min(10, len(body)); i++ {
-			fmt.Printf("%02x ", body[i])
-		}
-		fmt.Printf("\n")
-	}
-
-	// Check if response is HTML (error page)
-	bodyStr := string(body)
-	if len(bodyStr) > 0 && (strings.Contains(strings.ToLower(bodyStr), "<html>") || strings.Contains(strings.ToLower(bodyStr), "<!doctype html>")) {
-		fmt.Printf("ERROR: Received HTML/XML error response!\n")
-		fmt.Printf("Full Error Response:\n%s\n", bodyStr)
-		return nil, fmt.Errorf("received HTML response instead of JSON - API error: %s", resp.Status)
-	}
-
-	// Only show response body if it looks like valid JSON (starts with '{' or '[')
-	if len(bodyStr) > 0 && (bodyStr[0] == '{' || bodyStr[0] == '[') {
-		fmt.Printf("Raw Response Body:\n%s\n", bodyStr)
-	} else {
-		fmt.Printf("Response does not appear to be JSON. Full response:\n%s\n", bodyStr)
+		return nil, fmt.Errorf("failed to fetch road data for %s: %w", roadName, err)
 	}
 
 	var result OverpassResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		fmt.Printf("JSON Parse Error: %v\n", err)
-		fmt.Printf("Attempting to parse first 500 chars: %s\n", bodyStr[:min(500, len(bodyStr))])
 		return nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
-
-	fmt.Printf("Parsed Elements Count: %d\n", len(result.Elements))
-	for i, element := range result.Elements {
-		fmt.Printf("  Element %d: ID=%d, Type=%s, Name=%s, Geometry Points=%d\n",
-			i, element.ID, element.Type, element.Tags.Name, len(element.Geometry))
-		if len(element.Geometry) > 0 {
-			fmt.Printf("    First point: Lat=%.6f, Lon=%.6f\n", element.Geometry[0].Lat, element.Geometry[0].Lon)
-			fmt.Printf("    Last point:  Lat=%.6f, Lon=%.6f\n", element.Geometry[len(element.Geometry)-1].Lat, element.Geometry[len(element.Geometry)-1].Lon)
-		}
-	}
-	fmt.Printf("=== END DEBUG for '%s' ===\n\n", roadName)
+	fmt.Printf("Fetched %d ways for '%s'\n", len(result.Elements), roadName)
 
 	return &result, nil
 }
@@ -448,7 +346,7 @@ func main() {
 	}
 
 	// JSONとしてファイル出力
-	outFilePath := filepath.Join(*outdir, "hoge.json")
+	outFilePath := filepath.Join(*outdir, "violation_rates.json")
 	outFile, err := os.Create(outFilePath)
 	if err != nil {
 		fmt.Println("Error creating JSON file:", err)
@@ -457,8 +355,12 @@ func main() {
 	defer outFile.Close()
 
 	var results []ViolationRate
+	var fetchedAt time.Time // 一番新しいCSVの更新日時 (ダウンロードした日時)
 
 	for _, filename := range files {
+		if info, err := os.Stat(filename); err == nil && info.ModTime().After(fetchedAt) {
+			fetchedAt = info.ModTime()
+		}
 		f, err := os.Open(filename)
 		if err != nil {
 			fmt.Println("Error opening file:", err)
@@ -490,7 +392,11 @@ func main() {
 			ratio := sidewalk / cycleTotal
 			ratioRounded := roundToSignificantFigures(ratio, 2)
 
-			roads := strings.Split(record[2], "Ｘ")
+			roads := jpnorm.ParseLocation(record[2]).Roads
+			if len(roads) < 2 {
+				fmt.Printf("Not an intersection: %s\n", record[2])
+				continue
+			}
 			road1 := roads[0]
 			road2 := roads[1]
 			// Findで座標取得
@@ -502,6 +408,7 @@ func main() {
 
 			vr := ViolationRate{
 				Type:           "intersection",
+				Name:           road1 + "×" + road2,
 				ViolationRate:  ratioRounded,
 				ViolationCount: int(sidewalk),
 				Coordinate:     coord,
@@ -516,11 +423,25 @@ func main() {
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(results); err != nil {
 		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+	outFile.Close()
+
+	// 出典をサイドカーに書く (座標は OSM の道路から求める)
+	if err := provenance.Write(outFilePath, provenance.Metadata{
+		Dataset:   filepath.Base(outFilePath),
+		SourceURL: trafficCountDatasetURL,
+		Sources:   []provenance.Source{provenance.TokyoOpenData, provenance.OpenStreetMap},
+		FetchedAt: fetchedAt,
+		Records:   len(results),
+	}); err != nil {
+		fmt.Println("Error writing provenance:", err)
 	}
 }
 
 type ViolationRate struct {
 	Type           string    `json:"type,omitempty"`            //常に"intersection"
+	Name           string    `json:"name,omitempty"`            //交差する道路名 (外堀通り×第一京浜)
 	ViolationRate  float64   `json:"violation_rate"`            //歩道通行自転車/自転車計(有効数字2桁)
 	ViolationCount int       `json:"violation_count,omitempty"` //歩道通行自転車数(整数)
 	Coordinate     []float64 `json:"coordinate"`                //[経度, 緯度]
@@ -1,3 +1,9 @@
 module prepare_intersection
 
 go 1.24.5
+
+require template-mobile-app-api v0.0.0
+
+require golang.org/x/text v0.27.0 // indirect
+
+replace template-mobile-app-api => ../../api
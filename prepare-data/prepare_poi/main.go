@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"template-mobile-app-api/osm"
+	"template-mobile-app-api/provenance"
+)
+
+// POI はAPIが読み込む地点データ
+type POI struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Category   string            `json:"category"`
+	Coordinate []float64         `json:"coordinate"` // [経度, 緯度]
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+type OverpassElement struct {
+	Type   string  `json:"type"`
+	ID     int64   `json:"id"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Center *struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"center"`
+	Tags map[string]string `json:"tags"`
+}
+
+type OverpassResponse struct {
+	Elements []OverpassElement `json:"elements"`
+}
+
+// category はOSMのタグの組み合わせとAPIでのカテゴリ名の対応
+type category struct {
+	Name  string
+	Key   string
+	Value string
+	Named bool // 名前付きのものだけ取得する
+}
+
+var categories = []category{
+	{Name: "bicycle_parking", Key: "amenity", Value: "bicycle_parking"},
+	{Name: "park", Key: "leisure", Value: "park", Named: true},
+	{Name: "convenience", Key: "shop", Value: "convenience"},
+	{Name: "bicycle_shop", Key: "shop", Value: "bicycle"},
+	{Name: "bicycle_repair", Key: "amenity", Value: "bicycle_repair_station"},
+	{Name: "compressed_air", Key: "amenity", Value: "compressed_air"},
+	{Name: "toilets", Key: "amenity", Value: "toilets"},
+}
+
+// 残しておくタグ (営業時間など表示に使うものと、検索に使う読み・別名)
+var keepTags = []string{"opening_hours", "capacity", "covered", "fee", "brand", "addr:full", "addr:city", "addr:quarter", "addr:neighbourhood", "addr:block_number", "addr:housenumber",
+	"name:ja-Hira", "name:ja_kana", "name:ja-Hrkt", "name:ja_rm", "name:ja-Latn", "name:en", "alt_name", "short_name", "official_name"}
+
+// GazetteerEntry はAPIのオフライン検索で使う地名辞書の1件
+type GazetteerEntry struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Category   string    `json:"category"`
+	Coordinate []float64 `json:"coordinate"`        // [経度, 緯度]
+	Aliases    []string  `json:"aliases,omitempty"` // 読み・ローマ字・英語名・別名
+	Address    string    `json:"address,omitempty"`
+}
+
+// 地名辞書に入れるもの (名前付きのみ)
+// 町丁目(place=neighbourhood など)が住所の検索に、駅や施設が目的地の検索に使われる
+var gazetteerCategories = []category{
+	{Name: "suburb", Key: "place", Value: "suburb", Named: true},
+	{Name: "quarter", Key: "place", Value: "quarter", Named: true},
+	{Name: "neighbourhood", Key: "place", Value: "neighbourhood", Named: true},
+	{Name: "block", Key: "place", Value: "block", Named: true},
+	{Name: "station", Key: "railway", Value: "station", Named: true},
+	{Name: "school", Key: "amenity", Value: "school", Named: true},
+	{Name: "university", Key: "amenity", Value: "university", Named: true},
+	{Name: "hospital", Key: "amenity", Value: "hospital", Named: true},
+	{Name: "library", Key: "amenity", Value: "library", Named: true},
+	{Name: "townhall", Key: "amenity", Value: "townhall", Named: true},
+	{Name: "community_centre", Key: "amenity", Value: "community_centre", Named: true},
+	{Name: "attraction", Key: "tourism", Value: "attraction", Named: true},
+	{Name: "museum", Key: "tourism", Value: "museum", Named: true},
+	{Name: "mall", Key: "shop", Value: "mall", Named: true},
+}
+
+// 地名辞書の別名にするタグ
+var aliasTags = []string{"name:ja-Hira", "name:ja_kana", "name:ja-Hrkt", "name:ja_rm", "name:ja-Latn", "name:en", "alt_name", "short_name", "official_name", "old_name"}
+
+// 住所の組み立てに使うタグ (大きい単位から)
+var addressTags = []string{"addr:province", "addr:city", "addr:quarter", "addr:neighbourhood", "addr:block_number", "addr:housenumber"}
+
+func buildQuery(categories []category, bbox string) string {
+	var b strings.Builder
+	b.WriteString("[out:json][timeout:180];\n(\n")
+	for _, c := range categories {
+		filter := fmt.Sprintf("[\"%s\"=\"%s\"]", c.Key, c.Value)
+		if c.Named {
+			filter += "[\"name\"]"
+		}
+		fmt.Fprintf(&b, "  nwr%s(%s);\n", filter, bbox)
+	}
+	b.WriteString(");\nout center tags;\n")
+	return b.String()
+}
+
+func fetchPOIs(endpoint string, query string) ([]OverpassElement, error) {
+	// Overpass の利用規約に沿って、名乗った User-Agent・送信間隔・キャッシュを共有クライアントに任せる
+	body, err := osm.NewTool(200*time.Second).PostForm(context.Background(), endpoint, url.Values{"data": {query}})
+	if err != nil {
+		return nil, fmt.Errorf("APIリクエストエラー: %v", err)
+	}
+
+	var overpass OverpassResponse
+	if err := json.Unmarshal(body, &overpass); err != nil {
+		return nil, fmt.Errorf("JSON解析エラー: %v", err)
+	}
+	return overpass.Elements, nil
+}
+
+// categoryOf は要素が該当するカテゴリ名を返す
+func categoryOf(e OverpassElement, categories []category) string {
+	for _, c := range categories {
+		if e.Tags[c.Key] == c.Value {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// position は要素の座標 (way, relation は中心) を返す
+func position(e OverpassElement) (lat, lon float64) {
+	if e.Center != nil {
+		return e.Center.Lat, e.Center.Lon
+	}
+	return e.Lat, e.Lon
+}
+
+func convertToPOIs(elements []OverpassElement) []POI {
+	var pois []POI
+	for _, e := range elements {
+		lat, lon := position(e)
+		if lat == 0 || lon == 0 {
+			continue
+		}
+
+		name := categoryOf(e, categories)
+		if name == "" {
+			continue
+		}
+
+		tags := map[string]string{}
+		for _, key := range keepTags {
+			if v, ok := e.Tags[key]; ok {
+				tags[key] = v
+			}
+		}
+		if len(tags) == 0 {
+			tags = nil
+		}
+
+		pois = append(pois, POI{
+			ID:         fmt.Sprintf("osm.%s.%d", e.Type, e.ID),
+			Name:       e.Tags["name"],
+			Category:   name,
+			Coordinate: []float64{lon, lat},
+			Tags:       tags,
+		})
+	}
+	return pois
+}
+
+func convertToGazetteer(elements []OverpassElement) []GazetteerEntry {
+	var entries []GazetteerEntry
+	for _, e := range elements {
+		lat, lon := position(e)
+		if lat == 0 || lon == 0 || e.Tags["name"] == "" {
+			continue
+		}
+		name := categoryOf(e, gazetteerCategories)
+		if name == "" {
+			continue
+		}
+
+		var aliases []string
+		for _, key := range aliasTags {
+			if v := e.Tags[key]; v != "" && v != e.Tags["name"] {
+				aliases = append(aliases, v)
+			}
+		}
+		address := e.Tags["addr:full"]
+		if address == "" {
+			var parts []string
+			for _, key := range addressTags {
+				if v := e.Tags[key]; v != "" {
+					parts = append(parts, v)
+				}
+			}
+			address = strings.Join(parts, "")
+		}
+
+		entries = append(entries, GazetteerEntry{
+			ID:         fmt.Sprintf("osm.%s.%d", e.Type, e.ID),
+			Name:       e.Tags["name"],
+			Category:   name,
+			Coordinate: []float64{lon, lat},
+			Aliases:    aliases,
+			Address:    address,
+		})
+	}
+	return entries
+}
+
+func writeJSON(path string, v any) {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("ファイル作成エラー: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatalf("JSON書き込みエラー: %v", err)
+	}
+}
+
+// writeProvenance は OSM から取得したデータの出典をサイドカーに書く
+func writeProvenance(path, endpoint string, fetchedAt time.Time, records int) {
+	if err := provenance.Write(path, provenance.Metadata{
+		Dataset:   filepath.Base(path),
+		SourceURL: endpoint,
+		Sources:   []provenance.Source{provenance.OpenStreetMap},
+		FetchedAt: fetchedAt,
+		Records:   records,
+	}); err != nil {
+		log.Fatalf("出典の書き込みエラー: %v", err)
+	}
+}
+
+func main() {
+	outdir := flag.String("outdir", ".", "Output dir")
+	// 南,西,北,東 (デフォルトは東京23区周辺)
+	bbox := flag.String("bbox", "35.52,139.56,35.82,139.92", "Bounding box (south,west,north,east)")
+	endpoint := flag.String("endpoint", "https://overpass-api.de/api/interpreter", "Overpass API endpoint")
+	gazetteer := flag.Bool("gazetteer", true, "Also write gazetteer.json (place names and addresses for offline search)")
+	flag.Parse()
+
+	fmt.Println("OpenStreetMapから地点データを取得中...")
+	fetchedAt := time.Now()
+	elements, err := fetchPOIs(*endpoint, buildQuery(categories, *bbox))
+	if err != nil {
+		log.Fatalf("データ取得エラー: %v", err)
+	}
+	fmt.Printf("取得した要素数: %d\n", len(elements))
+
+	pois := convertToPOIs(elements)
+	counts := map[string]int{}
+	for _, p := range pois {
+		counts[p.Category]++
+	}
+	for _, c := range categories {
+		fmt.Printf("  %s: %d\n", c.Name, counts[c.Name])
+	}
+
+	outputFile := filepath.Join(*outdir, "pois.json")
+	writeJSON(outputFile, pois)
+	writeProvenance(outputFile, *endpoint, fetchedAt, len(pois))
+	fmt.Printf("地点データを %s に出力しました\n", outputFile)
+
+	if !*gazetteer {
+		return
+	}
+	fmt.Println("OpenStreetMapから地名・住所データを取得中...")
+	fetchedAt = time.Now()
+	elements, err = fetchPOIs(*endpoint, buildQuery(gazetteerCategories, *bbox))
+	if err != nil {
+		log.Fatalf("データ取得エラー: %v", err)
+	}
+	entries := convertToGazetteer(elements)
+	fmt.Printf("地名数: %d\n", len(entries))
+
+	outputFile = filepath.Join(*outdir, "gazetteer.json")
+	writeJSON(outputFile, entries)
+	writeProvenance(outputFile, *endpoint, fetchedAt, len(entries))
+	fmt.Printf("地名データを %s に出力しました\n", outputFile)
+}
@@ -2,14 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"time"
 
+	"template-mobile-app-api/provenance"
 	util "template-mobile-app-api/util"
 )
 
@@ -71,6 +72,7 @@ func main() {
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
+	fetchedAt := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Println("Request error:", err)
@@ -86,23 +88,21 @@ func main() {
 	worningIntersectionPoints := []util.WarningPoint{}
 	worningIntersectionResponse := WarningIntersectionResponse{}
 	json.Unmarshal(body, &worningIntersectionResponse)
-	for i, v := range worningIntersectionResponse.Hits {
-		//取締り強化交差点データのLocationには「〇〇付近」とあり、検索の邪魔なので消す。
-		Location := strings.Replace(v.Location, "付近", "", -1)
-		searchResp := util.GetSearchBase(Location)
-		fmt.Println(v.Location)
-		if searchResponse, ok := searchResp.([]util.SearchResponse); ok {
-			worningIntersectionPoints = append(worningIntersectionPoints, util.WarningPoint{})
-
-			if len(searchResponse) <= 0 {
-				continue
-			}
-			lon, _ := strconv.ParseFloat(searchResponse[0].Lon, 64)
-			lat, _ := strconv.ParseFloat(searchResponse[0].Lat, 64)
-			worningIntersectionPoints[i].Coordinate = []float64{lon, lat}
-			worningIntersectionPoints[i].Name = v.Location
-			worningIntersectionPoints[i].Message = v.Reason
+	for _, v := range worningIntersectionResponse.Hits {
+		//取締り強化交差点データのLocationには「〇〇付近」とあるので、正規化して検索する。
+		result, err := util.GeocodeLocation(context.Background(), v.Location)
+		if err != nil {
+			fmt.Printf("座標が見つかりません: %s (%v)\n", v.Location, err)
+			continue
 		}
+		fmt.Println(v.Location)
+		worningIntersectionPoints = append(worningIntersectionPoints, util.WarningPoint{
+			Type:       "intersection",
+			Coordinate: result.Coordinate,
+			Name:       v.Location,
+			Message:    v.Reason,
+			Ward:       v.Ward,
+		})
 	}
 	defer resp.Body.Close()
 
@@ -117,4 +117,16 @@ func main() {
 		fmt.Println("ファイル書き込みエラー:", err)
 		return
 	}
+
+	// 出典をサイドカーに書く (座標は /search と同じジオコーダで求める)
+	err = provenance.Write("warningIntersection.json", provenance.Metadata{
+		Dataset:   "warningIntersection.json",
+		SourceURL: url,
+		Sources:   []provenance.Source{provenance.TokyoOpenData, provenance.OpenStreetMap, provenance.GSI},
+		FetchedAt: fetchedAt,
+		Records:   len(worningIntersectionPoints),
+	})
+	if err != nil {
+		fmt.Println("出典の書き込みエラー:", err)
+	}
 }